@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// BatchJobSpec defines the desired state of BatchJob
+type BatchJobSpec struct {
+	// JobServiceAccount service account to be used for the job pods. If empty the default will be used
+	JobServiceAccount string `json:"jobServiceAccount,omitempty"`
+	// Target the targets to start the job pods for. If empty one pod is started per node
+	Target *Target `json:"target,omitempty"`
+	// JobNodeSelector node selector labels to define in which nodes to run the jobs
+	JobNodeSelector map[string]string `json:"jobNodeSelector,omitempty"`
+	// JobNodeLabelSelector label selector with match labels and expressions to define in which nodes to run the jobs
+	JobNodeLabelSelector *metav1.LabelSelector `json:"jobNodeLabelSelector,omitempty"`
+	// ExcludedNodes names of the nodes to never run the jobs on
+	ExcludedNodes []string `json:"excludedNodes,omitempty"`
+	// RunOnUnscheduledNodes if true, jobs are also started on nodes that are unschedulable
+	RunOnUnscheduledNodes bool `json:"runOnUnscheduledNodes,omitempty"`
+	// SkipUntoleratedNodes if true, jobs are not started on nodes with NoSchedule or NoExecute taints not tolerated by the pod template
+	SkipUntoleratedNodes bool `json:"skipUntoleratedNodes,omitempty"`
+	// CronExpression the cron expression to trigger the job execution
+	CronExpression string `json:"cronExpression"`
+	// CronTimeZone the time zone of the cron expression e.g. 'Europe/Zurich'. If empty the local time zone of the controller is used
+	CronTimeZone string `json:"cronTimeZone,omitempty"`
+	// ReportHistory number of execution reports to keep
+	ReportHistory int `json:"reportHistory,omitempty"`
+	// ExecutionIDFormat the go time layout of the execution ids, e.g. '20060102-150405'. If empty '20060102150405' is used
+	ExecutionIDFormat string `json:"executionIDFormat,omitempty"`
+	// PodPoolSize number of concurrent job pods to run. If empty 10 pods are run concurrently
+	// +kubebuilder:validation:Minimum=1
+	PodPoolSize int `json:"podPoolSize,omitempty"`
+	// RunOnStartup if 'true' the jobs are triggered on startup of the controller
+	RunOnStartup bool `json:"runOnStartup,omitempty"`
+	// StartingDeadlineSeconds deadline in seconds to start a missed scheduled execution, e.g. when the controller was down.
+	// Missed executions older than the deadline are not started. If empty missed executions are not started
+	// +kubebuilder:validation:Minimum=0
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// JobTimeout the maximum duration of a job pod, after which the pod is deleted. If empty pods do not time out
+	JobTimeout *metav1.Duration `json:"jobTimeout,omitempty"`
+	// Retry the retry config of failed job pods
+	Retry *Retry `json:"retry,omitempty"`
+	// ConcurrencyPolicy how to treat a new execution while the last execution is still active
+	// Allow (default): run both, Forbid: skip the new execution, Replace: cancel the active execution
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty"`
+	// Metrics the metrics exposed by the controller
+	Metrics Metrics `json:"metrics,omitempty"`
+	// Custom additional properties that can be used in a custom implementation
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Custom *runtime.RawExtension `json:"custom,omitempty"`
+	// Template the template of the pod to be started for each job
+	Template corev1.PodTemplateSpec `json:"template"`
+}
+
+// Target config
+type Target struct {
+	// Kind the kind of the targets
+	// +kubebuilder:validation:Enum=node;namespace;persistentVolume;static
+	Kind string `json:"kind,omitempty"`
+	// LabelSelector the selector of the namespaces or persistent volumes
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	// Items the names of the static targets
+	Items []string `json:"items,omitempty"`
+}
+
+// Retry config
+type Retry struct {
+	// MaxAttempts maximum number of attempts per node including the first one
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff duration to wait before a pod is recreated
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+	// OnFailure if 'true' failed pods are retried
+	OnFailure bool `json:"onFailure,omitempty"`
+	// OnMissingReport if 'true' pods that did not send a report are retried
+	OnMissingReport bool `json:"onMissingReport,omitempty"`
+}
+
+// Metrics config
+type Metrics struct {
+	// Prefix for the metrics exposed by the controller
+	Prefix string `json:"prefix,omitempty"`
+	// Gauges metric gauges that will be exposed by the jobs. The key is uses as suffix for the metrics.
+	Gauges map[string]Metric `json:"gauges,omitempty"`
+	// Counters metric counters that will be exposed by the jobs, the reported values are added.
+	Counters map[string]Metric `json:"counters,omitempty"`
+	// Histograms metric histograms that will be exposed by the jobs, the reported values are observed.
+	Histograms map[string]Histogram `json:"histograms,omitempty"`
+	// Info metrics that will be exposed by the jobs with the reported labels and the value 1.
+	Info map[string]Metric `json:"info,omitempty"`
+	// Lenient if 'true' reports with unknown metrics or missing and extra labels are accepted
+	Lenient bool `json:"lenient,omitempty"`
+	// DropExecutionIDLabel if 'true' the metrics are exposed without the executionID label, only the latest values per node are kept
+	DropExecutionIDLabel bool `json:"dropExecutionIDLabel,omitempty"`
+}
+
+// Metric config
+type Metric struct {
+	// Help text for the metric
+	Help string `json:"help,omitempty"`
+	// Labels list of labels to be used with the metric. node and executionID are automatically added
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Histogram config
+type Histogram struct {
+	Metric `json:",inline"`
+	// Buckets the upper bounds of the buckets in increasing order as decimal numbers e.g. "0.5".
+	// The prometheus default buckets are used if empty
+	Buckets []string `json:"buckets,omitempty"`
+}
+
+// BatchJobStatus defines the observed state of BatchJob
+type BatchJobStatus struct {
+	// LastExecutionID the id of the last execution
+	LastExecutionID string `json:"lastExecutionID,omitempty"`
+	// PodsStarted the number of pods started for the last execution
+	PodsStarted int `json:"podsStarted"`
+	// PodsSucceeded the number of succeeded pods of the last execution
+	PodsSucceeded int `json:"podsSucceeded"`
+	// PodsFailed the number of failed pods of the last execution
+	PodsFailed int `json:"podsFailed"`
+	// LastReportTime the time the last report was received
+	LastReportTime *metav1.Time `json:"lastReportTime,omitempty"`
+	// Error the reason why the batch job is invalid and ignored by the controller
+	Error string `json:"error,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cron",type="string",JSONPath=".spec.cronExpression"
+// +kubebuilder:printcolumn:name="Execution",type="string",JSONPath=".status.lastExecutionID"
+// +kubebuilder:printcolumn:name="Succeeded",type="integer",JSONPath=".status.podsSucceeded"
+// +kubebuilder:printcolumn:name="Failed",type="integer",JSONPath=".status.podsFailed"
+
+// BatchJob is the Schema for the batchjobs API
+type BatchJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BatchJobSpec   `json:"spec,omitempty"`
+	Status BatchJobStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BatchJobList contains a list of BatchJob
+type BatchJobList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BatchJob `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BatchJob{}, &BatchJobList{})
+}
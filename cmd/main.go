@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/bakito/batch-job-controller/api/v1alpha1"
 	bjcc "github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/controller"
 	"github.com/bakito/batch-job-controller/pkg/cron"
@@ -40,6 +41,7 @@ var (
 func init() {
 	utilruntime.Must(corev1.AddToScheme(scheme))
 	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 }
 
 // Setup setup main
@@ -75,18 +77,31 @@ func Setup() *Main {
 		os.Exit(1)
 	}
 
-	cfg, err := bjcc.Get(namespace, mgr.GetAPIReader())
+	var cfg *bjcc.Config
+	if strings.ToLower(os.Getenv(bjcc.EnvBatchJobs)) == "true" {
+		var invalid map[string]error
+		cfg, invalid, err = bjcc.GetFromBatchJobs(namespace, mgr.GetAPIReader())
+		for name, e := range invalid {
+			// the error is written to the status of the batch job by the reconciler
+			setupLog.Error(e, "ignoring invalid batch job", "batchjob", name)
+		}
+	} else {
+		cfg, err = bjcc.Get(namespace, mgr.GetAPIReader())
+	}
 	if err != nil {
 		setupLog.Error(err, "unable to get config")
 		os.Exit(1)
 	}
+	if err := cfg.Validate(); err != nil {
+		setupLog.Error(err, "config is invalid")
+		os.Exit(1)
+	}
 
-	pc, err := lifecycle.NewPromCollector(cfg)
+	cache, err := lifecycle.NewCache(cfg)
 	if err != nil {
-		setupLog.Error(err, "error creating prometheus collector")
+		setupLog.Error(err, "error creating cache")
 		os.Exit(1)
 	}
-	cache := lifecycle.NewCache(cfg, pc)
 
 	return &Main{
 		Cache:   cache,
@@ -99,6 +114,9 @@ func Setup() *Main {
 func (m *Main) Start(runnables ...manager.Runnable) {
 
 	var envExtender []job.CustomPodEnv
+	var targetFilters []cron.TargetFilter
+	var podMutators []job.CustomPodMutator
+	var configTargets []inject.Config
 
 	var eventRecorder record.EventRecorder
 	// setup runnables
@@ -112,6 +130,7 @@ func (m *Main) Start(runnables ...manager.Runnable) {
 
 		if c, ok := r.(inject.Config); ok {
 			c.InjectConfig(m.Config)
+			configTargets = append(configTargets, c)
 		}
 		if c, ok := r.(inject.Cache); ok {
 			c.InjectCache(m.Cache)
@@ -126,26 +145,101 @@ func (m *Main) Start(runnables ...manager.Runnable) {
 			setupLog.WithValues("extender", c).Info("registering custom pod env extender")
 			envExtender = append(envExtender, e)
 		}
+		if pm, ok := r.(job.CustomPodMutator); ok {
+			c := reflect.TypeOf(r)
+			setupLog.WithValues("mutator", c).Info("registering custom pod mutator")
+			podMutators = append(podMutators, pm)
+		}
+		if f, ok := r.(cron.TargetFilter); ok {
+			c := reflect.TypeOf(r)
+			setupLog.WithValues("filter", c).Info("registering target filter")
+			targetFilters = append(targetFilters, f)
+		}
+		if h, ok := r.(inject.CompletionHook); ok {
+			c := reflect.TypeOf(r)
+			setupLog.WithValues("hook", c).Info("registering completion hook")
+			m.Cache.AddCompletionHook(h)
+		}
+	}
+
+	if er, ok := m.Cache.(inject.EventRecorder); ok {
+		if eventRecorder == nil {
+			eventRecorder = m.Manager.GetEventRecorderFor(m.Config.Name)
+		}
+		er.InjectEventRecorder(eventRecorder)
 	}
 
 	// setup cron job
-	cj, err := cron.Job(namespace, m.Config, m.Manager.GetClient(), m.Cache, m.Config.Owner, envExtender...)
+	cj, err := cron.Job(namespace, m.Config, m.Manager.GetClient(), m.Cache, m.Config.Owner, targetFilters, podMutators, envExtender...)
 	if err != nil {
 		setupLog.Error(err, "unable to set up cron job")
 		os.Exit(1)
 	}
 
-	cj.Start()
+	if er, ok := cj.(inject.EventRecorder); ok {
+		if eventRecorder == nil {
+			eventRecorder = m.Manager.GetEventRecorderFor(m.Config.Name)
+		}
+		er.InjectEventRecorder(eventRecorder)
+	}
+	for _, r := range runnables {
+		if s, ok := r.(inject.Scheduler); ok {
+			s.InjectScheduler(cj)
+		}
+	}
+
+	// the state of running executions is restored once the manager is elected as leader, before the scheduler is started
+	restored := make(chan struct{})
+	_ = m.Manager.Add(controller.RestoreCacheBefore(m.Manager.GetClient(), namespace, m.Cache, restored, cj))
+
+	configTargets = append(configTargets, cj)
+	if c, ok := m.Cache.(inject.Config); ok {
+		configTargets = append(configTargets, c)
+	}
 
 	// Setup a new controller to reconcile ReplicaSets
 	setupLog.Info("Setting up controller")
 
+	if strings.ToLower(os.Getenv(bjcc.EnvBatchJobs)) == "true" {
+		bjr := &controller.BatchJobReconciler{
+			Client:    m.Manager.GetClient(),
+			Log:       ctrl.Log.WithName("controllers").WithName("BatchJob"),
+			Namespace: namespace,
+			Config:    m.Config,
+			Targets:   configTargets,
+		}
+		if err = bjr.SetupWithManager(m.Manager); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "BatchJob")
+			os.Exit(1)
+		}
+		m.Cache.AddListener(bjr)
+	} else if err = (&controller.ConfigMapReconciler{
+		Client:  m.Manager.GetClient(),
+		Log:     ctrl.Log.WithName("controllers").WithName("ConfigMap"),
+		Name:    os.Getenv(bjcc.EnvConfigMapName),
+		Config:  m.Config,
+		Targets: configTargets,
+	}).SetupWithManager(m.Manager); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ConfigMap")
+		os.Exit(1)
+	}
+
 	if err = (&controller.PodReconciler{
+		Client:   m.Manager.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Pod"),
+		Cache:    m.Cache,
+		Restored: restored,
+	}).SetupWithManager(m.Manager); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Pod")
+		os.Exit(1)
+	}
+
+	if err = (&controller.NodeReconciler{
 		Client: m.Manager.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Pod"),
+		Log:    ctrl.Log.WithName("controllers").WithName("Node"),
 		Cache:  m.Cache,
 	}).SetupWithManager(m.Manager); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Pod")
+		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
 
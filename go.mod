@@ -3,6 +3,7 @@ module github.com/bakito/batch-job-controller
 go 1.14
 
 require (
+	github.com/Masterminds/sprig/v3 v3.1.0
 	github.com/ghodss/yaml v1.0.0
 	// fix untli 0.2.1 is released https://github.com/go-logr/logr/issues/22
 	github.com/go-logr/logr v0.2.1-0.20200730175230-ee2de8da5be6
@@ -14,6 +15,7 @@ require (
 	github.com/onsi/ginkgo v1.14.0
 	github.com/onsi/gomega v1.10.1
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.13.0
 	github.com/robfig/cron/v3 v3.0.1
 	google.golang.org/appengine v1.6.1 // indirect
@@ -2,15 +2,18 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"sort"
+	"strconv"
 
+	"github.com/bakito/batch-job-controller/api/v1alpha1"
+	sigsyaml "github.com/ghodss/yaml"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/yaml"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -24,6 +27,19 @@ const (
 	PodTemplateName = "pod-template.yaml"
 	// ConfigFileName key of the config yaml file in the configmap
 	ConfigFileName = "config.yaml"
+	// EnvBatchJobs env variable to read the jobs from the batch job resources, if set to 'true'
+	EnvBatchJobs = "BATCH_JOBS"
+	// EnvCallbackServiceName callback service name env variable used with the batch job resource
+	EnvCallbackServiceName = "CALLBACK_SERVICE_NAME"
+	// EnvCallbackServicePort callback service port env variable used with the batch job resource
+	EnvCallbackServicePort = "CALLBACK_SERVICE_PORT"
+	// EnvReportDirectory report directory env variable used with the batch job resource
+	EnvReportDirectory = "REPORT_DIRECTORY"
+
+	defaultCallbackServicePort = 8090
+	defaultReportDirectory     = "/var/www"
+	batchJobsConfigName        = "batch-job-controller"
+	batchJobsMetricsPrefix     = "batch_job"
 )
 
 var (
@@ -37,28 +53,205 @@ func Get(namespace string, cl client.Reader) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	cfg, err := FromConfigMap(namespace, cm)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Owner = findPodOwner(namespace, cl)
+
+	return cfg, nil
+}
+
+// FromConfigMap parse the config from the given configmap
+func FromConfigMap(namespace string, cm *corev1.ConfigMap) (*Config, error) {
 	if c, ok := cm.Data[ConfigFileName]; ok {
 		cfg := &Config{}
-		decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(c), 20)
-		err = decoder.Decode(cfg)
-
+		err := decodeStrict(c, cfg)
 		if err != nil {
-			return nil, fmt.Errorf("could not read config file %q in configmap %q: %v", ConfigFileName, os.Getenv(EnvConfigMapName), err)
+			return nil, fmt.Errorf("could not read config file %q in configmap %q: %v", ConfigFileName, cm.Name, err)
 		}
 
-		if t, ok := cm.Data[PodTemplateName]; ok {
-			cfg.JobPodTemplate = t
+		if len(cfg.Jobs) == 0 {
+			if cfg.JobPodTemplate, err = podTemplate(cm, cfg); err != nil {
+				return nil, err
+			}
 		} else {
-			return nil, fmt.Errorf("could not find pod template %q in configmap %q", PodTemplateName, os.Getenv(EnvConfigMapName))
+			for i := range cfg.Jobs {
+				if cfg.Jobs[i].JobPodTemplate, err = podTemplate(cm, &cfg.Jobs[i]); err != nil {
+					return nil, err
+				}
+			}
 		}
 
 		cfg.Namespace = namespace
 
-		cfg.Owner = findPodOwner(namespace, cl)
-
 		return cfg, nil
 	}
-	return nil, fmt.Errorf("could not find config file %q in configmap %q", ConfigFileName, os.Getenv(EnvConfigMapName))
+	return nil, fmt.Errorf("could not find config file %q in configmap %q", ConfigFileName, cm.Name)
+}
+
+func podTemplate(cm *corev1.ConfigMap, cfg *Config) (string, error) {
+	name := cfg.PodTemplate
+	if name == "" {
+		name = PodTemplateName
+	}
+	if t, ok := cm.Data[name]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("could not find pod template %q in configmap %q", name, cm.Name)
+}
+
+// GetFromBatchJobs read the config from all batch job resources in the namespace
+// the invalid batch jobs are skipped and returned with their error by name
+func GetFromBatchJobs(namespace string, cl client.Reader) (*Config, map[string]error, error) {
+	bjs := &v1alpha1.BatchJobList{}
+	err := cl.List(context.TODO(), bjs, client.InNamespace(namespace))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing batch jobs: %v", err)
+	}
+
+	cfg, invalid, err := FromBatchJobs(namespace, bjs.Items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg.Owner = findPodOwner(namespace, cl)
+
+	return cfg, invalid, nil
+}
+
+// FromBatchJobs convert the batch job resources into a config with one job per resource
+// the settings of the controller itself are read from the env variables.
+// Each batch job is validated on its own, the invalid batch jobs are skipped and returned with their error by name.
+func FromBatchJobs(namespace string, bjs []v1alpha1.BatchJob) (*Config, map[string]error, error) {
+	cfg := &Config{
+		Name:      batchJobsConfigName,
+		Namespace: namespace,
+		Metrics:   Metrics{Prefix: batchJobsMetricsPrefix},
+		jobsOnly:  true,
+	}
+	if err := controllerSettingsFromEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(bjs, func(i, j int) bool {
+		return bjs[i].Name < bjs[j].Name
+	})
+	invalid := make(map[string]error)
+	for i := range bjs {
+		jc, err := FromBatchJob(&bjs[i])
+		if err == nil {
+			// validate the job together with the valid jobs to detect conflicts, e.g. duplicate metrics prefixes
+			candidate := *cfg
+			candidate.Jobs = append(append([]Config{}, cfg.Jobs...), *jc)
+			err = candidate.Validate()
+		}
+		if err != nil {
+			invalid[bjs[i].Name] = err
+			continue
+		}
+		cfg.Jobs = append(cfg.Jobs, *jc)
+	}
+	return cfg, invalid, nil
+}
+
+// FromBatchJob convert the batch job resource into a config
+// the settings of the controller itself are read from the env variables
+func FromBatchJob(bj *v1alpha1.BatchJob) (*Config, error) {
+	cfg := &Config{
+		Name:                  bj.Name,
+		Namespace:             bj.Namespace,
+		JobServiceAccount:     bj.Spec.JobServiceAccount,
+		JobNodeSelector:       bj.Spec.JobNodeSelector,
+		JobNodeLabelSelector:  bj.Spec.JobNodeLabelSelector,
+		ExcludedNodes:         bj.Spec.ExcludedNodes,
+		RunOnUnscheduledNodes: bj.Spec.RunOnUnscheduledNodes,
+		SkipUntoleratedNodes:  bj.Spec.SkipUntoleratedNodes,
+		CronExpression:        bj.Spec.CronExpression,
+		CronTimeZone:          bj.Spec.CronTimeZone,
+		ReportHistory:         bj.Spec.ReportHistory,
+		ExecutionIDFormat:     bj.Spec.ExecutionIDFormat,
+		PodPoolSize:           DefaultPodPoolSize,
+		RunOnStartup:          bj.Spec.RunOnStartup,
+		ConcurrencyPolicy:     ConcurrencyPolicy(bj.Spec.ConcurrencyPolicy),
+		Metrics: Metrics{
+			Prefix:               bj.Spec.Metrics.Prefix,
+			Lenient:              bj.Spec.Metrics.Lenient,
+			DropExecutionIDLabel: bj.Spec.Metrics.DropExecutionIDLabel,
+		},
+	}
+	if err := controllerSettingsFromEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if bj.Spec.PodPoolSize > 0 {
+		cfg.PodPoolSize = bj.Spec.PodPoolSize
+	}
+	if bj.Spec.StartingDeadlineSeconds != nil {
+		cfg.StartingDeadline = int(*bj.Spec.StartingDeadlineSeconds)
+	}
+	if bj.Spec.JobTimeout != nil {
+		cfg.JobTimeout = *bj.Spec.JobTimeout
+	}
+	if t := bj.Spec.Target; t != nil {
+		cfg.Target = Target{
+			Kind:          t.Kind,
+			LabelSelector: t.LabelSelector,
+			Items:         t.Items,
+		}
+	}
+	if r := bj.Spec.Retry; r != nil {
+		cfg.Retry = Retry{
+			MaxAttempts:     r.MaxAttempts,
+			OnFailure:       r.OnFailure,
+			OnMissingReport: r.OnMissingReport,
+		}
+		if r.Backoff != nil {
+			cfg.Retry.Backoff = *r.Backoff
+		}
+	}
+
+	cfg.Metrics.Gauges = metricsFromBatchJob(bj.Spec.Metrics.Gauges)
+	cfg.Metrics.Counters = metricsFromBatchJob(bj.Spec.Metrics.Counters)
+	cfg.Metrics.Info = metricsFromBatchJob(bj.Spec.Metrics.Info)
+	if len(bj.Spec.Metrics.Histograms) > 0 {
+		cfg.Metrics.Histograms = make(map[string]Histogram)
+		for name, h := range bj.Spec.Metrics.Histograms {
+			histogram := Histogram{Metric: Metric{Help: h.Help, Labels: h.Labels}}
+			for _, b := range h.Buckets {
+				bucket, err := strconv.ParseFloat(b, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid bucket %q of histogram %q of batch job %q: %v", b, name, bj.Name, err)
+				}
+				histogram.Buckets = append(histogram.Buckets, bucket)
+			}
+			cfg.Metrics.Histograms[name] = histogram
+		}
+	}
+
+	if bj.Spec.Custom != nil && len(bj.Spec.Custom.Raw) > 0 {
+		if err := json.Unmarshal(bj.Spec.Custom.Raw, &cfg.Custom); err != nil {
+			return nil, fmt.Errorf("could not read custom config of batch job %q: %v", bj.Name, err)
+		}
+	}
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: bj.Spec.Template.ObjectMeta,
+		Spec:       bj.Spec.Template.Spec,
+	}
+	t, err := sigsyaml.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("could not read pod template of batch job %q: %v", bj.Name, err)
+	}
+	cfg.JobPodTemplate = string(t)
+
+	return cfg, nil
 }
 
 func configMap(namespace string, cl client.Reader) (*corev1.ConfigMap, error) {
@@ -106,3 +299,36 @@ func findOwner(obj runtime.Object, namespace string, name string, cl client.Read
 	}
 	return name, nil
 }
+
+// controllerSettingsFromEnv read the settings of the controller itself from the env variables
+func controllerSettingsFromEnv(cfg *Config) error {
+	cfg.CallbackServiceName = os.Getenv(EnvCallbackServiceName)
+	cfg.CallbackServicePort = defaultCallbackServicePort
+	cfg.ReportDirectory = defaultReportDirectory
+
+	if value, exists := os.LookupEnv(EnvCallbackServicePort); exists {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q of env variable %q: %v", value, EnvCallbackServicePort, err)
+		}
+		cfg.CallbackServicePort = port
+	}
+	if value, exists := os.LookupEnv(EnvReportDirectory); exists {
+		cfg.ReportDirectory = value
+	}
+	return nil
+}
+
+func metricsFromBatchJob(metrics map[string]v1alpha1.Metric) map[string]Metric {
+	if len(metrics) == 0 {
+		return nil
+	}
+	out := make(map[string]Metric)
+	for name, m := range metrics {
+		out[name] = Metric{
+			Help:   m.Help,
+			Labels: m.Labels,
+		}
+	}
+	return out
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/bakito/batch-job-controller/api/v1alpha1"
 	"github.com/bakito/batch-job-controller/pkg/config"
 	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
 	gm "github.com/golang/mock/gomock"
@@ -52,6 +54,308 @@ var _ = Describe("Config", func() {
 		It("should return a correct name", func() {
 			Ω(c.PodName(node, id)).Should(Equal(fmt.Sprintf("%s-job-%s-%s", name, nodeName, id)))
 		})
+		It("should keep the full name of persistent volume targets", func() {
+			c.Target.Kind = config.TargetKindPersistentVolume
+			Ω(c.PodName("nfs.a", id)).Should(Equal(fmt.Sprintf("%s-job-nfs.a-%s", name, id)))
+			Ω(c.PodName("nfs.b", id)).ShouldNot(Equal(c.PodName("nfs.a", id)))
+		})
+	})
+	Context("NodeSelector", func() {
+		It("should combine the node selector labels and expressions", func() {
+			c := &config.Config{
+				JobNodeSelector: map[string]string{"env": "prod"},
+				JobNodeLabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"zone": "a"},
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "role", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"master"}},
+						{Key: "ssd", Operator: metav1.LabelSelectorOpExists},
+					},
+				},
+			}
+			selector, err := c.NodeSelector()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(selector.String()).Should(Equal("env=prod,role notin (master),ssd,zone=a"))
+		})
+		It("should select all nodes without selector", func() {
+			selector, err := (&config.Config{}).NodeSelector()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(selector.Empty()).Should(BeTrue())
+		})
+		It("should fail with an invalid operator", func() {
+			c := &config.Config{
+				JobNodeLabelSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "role", Operator: "Maybe"}},
+				},
+			}
+			_, err := c.NodeSelector()
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should exclude nodes", func() {
+			c := &config.Config{ExcludedNodes: []string{"a"}}
+			Ω(c.IsExcluded("a")).Should(BeTrue())
+			Ω(c.IsExcluded("b")).Should(BeFalse())
+		})
+	})
+	Context("Schedule", func() {
+		It("should prefix the expression with the time zone", func() {
+			Ω((&config.Config{CronExpression: "0 3 * * *"}).Schedule()).Should(Equal("0 3 * * *"))
+			Ω((&config.Config{CronExpression: "0 3 * * *", CronTimeZone: "Europe/Zurich"}).Schedule()).
+				Should(Equal("CRON_TZ=Europe/Zurich 0 3 * * *"))
+		})
+		It("should keep the time zone of the expression", func() {
+			Ω((&config.Config{CronExpression: "CRON_TZ=UTC 0 3 * * *", CronTimeZone: "Europe/Zurich"}).Schedule()).
+				Should(Equal("CRON_TZ=UTC 0 3 * * *"))
+		})
+	})
+	Context("Concurrency", func() {
+		It("should allow concurrent executions by default", func() {
+			Ω((&config.Config{}).Concurrency()).Should(Equal(config.AllowConcurrent))
+			Ω((&config.Config{ConcurrencyPolicy: config.ForbidConcurrent}).Concurrency()).Should(Equal(config.ForbidConcurrent))
+		})
+	})
+	Context("Retry", func() {
+		var (
+			r *config.Retry
+		)
+		BeforeEach(func() {
+			r = &config.Retry{
+				MaxAttempts: 2,
+				OnFailure:   true,
+			}
+		})
+		It("should retry failed pods until the max attempts are reached", func() {
+			Ω(r.ShouldRetry(1, true, true)).Should(BeTrue())
+			Ω(r.ShouldRetry(2, true, true)).Should(BeFalse())
+			Ω(r.ShouldRetry(1, false, true)).Should(BeFalse())
+		})
+		It("should retry on missing reports if enabled", func() {
+			Ω(r.ShouldRetry(1, false, false)).Should(BeFalse())
+			r.OnMissingReport = true
+			Ω(r.ShouldRetry(1, false, false)).Should(BeTrue())
+		})
+		It("should not retry without max attempts", func() {
+			r.MaxAttempts = 0
+			Ω(r.ShouldRetry(1, true, false)).Should(BeFalse())
+		})
+	})
+
+	Context("JobConfigs", func() {
+		var (
+			c *config.Config
+		)
+		BeforeEach(func() {
+			c = &config.Config{
+				Name:                "main",
+				Namespace:           "ns",
+				CallbackServiceName: "svc",
+				CallbackServicePort: 8090,
+				ReportDirectory:     "/var/www",
+				PodPoolSize:         3,
+				ReportHistory:       5,
+				JobServiceAccount:   "sa",
+				Retry:               config.Retry{MaxAttempts: 3, OnFailure: true},
+				ConcurrencyPolicy:   config.ReplaceConcurrent,
+				StartingDeadline:    300,
+				CronTimeZone:        "Europe/Zurich",
+				ExcludedNodes:       []string{"node"},
+				ExecutionIDFormat:   "20060102-150405",
+				Target:              config.Target{Kind: config.TargetKindStatic, Items: []string{"a"}},
+				Metrics: config.Metrics{
+					Prefix: "main",
+				},
+			}
+		})
+		It("should run the pods per node by default", func() {
+			Ω((&config.Config{}).TargetKind()).Should(Equal(config.TargetKindNode))
+		})
+		It("should return the config itself if no jobs are defined", func() {
+			Ω(c.JobConfigs()).Should(Equal([]*config.Config{c}))
+			Ω(c.JobConfig("main")).Should(Equal(c))
+			Ω(c.JobConfig("other")).Should(BeNil())
+		})
+		It("should return the jobs with inherited settings", func() {
+			c.Jobs = []config.Config{
+				{Name: "job-a"},
+				{Name: "b", PodPoolSize: 1, Metrics: config.Metrics{Prefix: "b"}, Target: config.Target{Kind: config.TargetKindNamespace}},
+			}
+			jobs := c.JobConfigs()
+			Ω(jobs).Should(HaveLen(2))
+
+			Ω(jobs[0].Name).Should(Equal("job-a"))
+			Ω(jobs[0].Namespace).Should(Equal("ns"))
+			Ω(jobs[0].CallbackServiceName).Should(Equal("svc"))
+			Ω(jobs[0].CallbackServicePort).Should(Equal(8090))
+			Ω(jobs[0].ReportDirectory).Should(Equal("/var/www/job-a"))
+			Ω(jobs[0].PodPoolSize).Should(Equal(3))
+			Ω(jobs[0].ReportHistory).Should(Equal(5))
+			Ω(jobs[0].JobServiceAccount).Should(Equal("sa"))
+			Ω(jobs[0].Metrics.Prefix).Should(Equal("main_job_a"))
+			Ω(jobs[0].Retry.MaxAttempts).Should(Equal(3))
+			Ω(jobs[0].Concurrency()).Should(Equal(config.ReplaceConcurrent))
+			Ω(jobs[0].StartingDeadlineDuration()).Should(Equal(5 * time.Minute))
+			Ω(jobs[0].CronTimeZone).Should(Equal("Europe/Zurich"))
+			Ω(jobs[0].ExcludedNodes).Should(Equal([]string{"node"}))
+			Ω(jobs[0].TargetKind()).Should(Equal(config.TargetKindStatic))
+			Ω(jobs[0].IDFormat()).Should(Equal("20060102-150405"))
+			Ω(jobs[0].Target.Items).Should(Equal([]string{"a"}))
+
+			Ω(jobs[1].PodPoolSize).Should(Equal(1))
+			Ω(jobs[1].Metrics.Prefix).Should(Equal("b"))
+			Ω(jobs[1].TargetKind()).Should(Equal(config.TargetKindNamespace))
+
+			Ω(c.JobConfig("b")).Should(Equal(jobs[1]))
+			Ω(c.JobConfig("main")).Should(BeNil())
+		})
+	})
+
+	Context("FromConfigMap", func() {
+		It("should read the pod template of each job", func() {
+			cm := &corev1.ConfigMap{
+				Data: map[string]string{
+					config.ConfigFileName:  "jobs:\n- name: a\n- name: b\n  podTemplate: b.yaml",
+					config.PodTemplateName: "kind: Pod",
+					"b.yaml":               "kind: Pod\nmetadata:\n  name: b",
+				},
+			}
+			c, err := config.FromConfigMap("ns", cm)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Jobs).Should(HaveLen(2))
+			Ω(c.JobConfig("a").JobPodTemplate).Should(Equal("kind: Pod"))
+			Ω(c.JobConfig("b").JobPodTemplate).Should(ContainSubstring("name: b"))
+		})
+		It("should fail if the pod template of a job is missing", func() {
+			cm := &corev1.ConfigMap{
+				Data: map[string]string{
+					config.ConfigFileName: "jobs:\n- name: a\n  podTemplate: a.yaml",
+				},
+			}
+			_, err := config.FromConfigMap("ns", cm)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring(`could not find pod template "a.yaml"`))
+		})
+	})
+
+	Context("FromBatchJob", func() {
+		var (
+			bj *v1alpha1.BatchJob
+		)
+		BeforeEach(func() {
+			deadline := int64(60)
+			_ = os.Unsetenv(config.EnvCallbackServicePort)
+			_ = os.Unsetenv(config.EnvReportDirectory)
+			_ = os.Setenv(config.EnvCallbackServiceName, "svc")
+			bj = &v1alpha1.BatchJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo",
+					Namespace: "bar",
+				},
+				Spec: v1alpha1.BatchJobSpec{
+					CronExpression:          "* * * * *",
+					PodPoolSize:             3,
+					JobTimeout:              &metav1.Duration{Duration: time.Minute},
+					Retry:                   &v1alpha1.Retry{MaxAttempts: 2, OnFailure: true},
+					StartingDeadlineSeconds: &deadline,
+					Metrics: v1alpha1.Metrics{
+						Prefix:               "foo",
+						Gauges:               map[string]v1alpha1.Metric{"a": {Help: "help", Labels: []string{"l"}}},
+						Lenient:              true,
+						DropExecutionIDLabel: true,
+						Histograms: map[string]v1alpha1.Histogram{
+							"h": {Metric: v1alpha1.Metric{Help: "help"}, Buckets: []string{"0.5", "1"}},
+						},
+					},
+					Custom: &runtime.RawExtension{Raw: []byte(`{"key":"value"}`)},
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "c", Image: "{{ .NodeName }}"}},
+						},
+					},
+				},
+			}
+		})
+		It("should convert the batch job", func() {
+			c, err := config.FromBatchJob(bj)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Name).Should(Equal("foo"))
+			Ω(c.Namespace).Should(Equal("bar"))
+			Ω(c.CronExpression).Should(Equal("* * * * *"))
+			Ω(c.PodPoolSize).Should(Equal(3))
+			Ω(c.JobTimeout.Duration).Should(Equal(time.Minute))
+			Ω(c.Retry.MaxAttempts).Should(Equal(2))
+			Ω(c.Retry.OnFailure).Should(BeTrue())
+			Ω(c.StartingDeadline).Should(Equal(60))
+			Ω(c.Metrics.Prefix).Should(Equal("foo"))
+			Ω(c.Metrics.Gauges).Should(HaveKey("a"))
+			Ω(c.Metrics.Lenient).Should(BeTrue())
+			Ω(c.Metrics.DropExecutionIDLabel).Should(BeTrue())
+			Ω(c.Metrics.Histograms["h"].Buckets).Should(Equal([]float64{0.5, 1}))
+			Ω(c.Custom).Should(HaveKeyWithValue("key", "value"))
+			Ω(c.CallbackServiceName).Should(Equal("svc"))
+			Ω(c.CallbackServicePort).Should(Equal(8090))
+			Ω(c.ReportDirectory).Should(Equal("/var/www"))
+			Ω(c.JobPodTemplate).Should(ContainSubstring("kind: Pod"))
+			Ω(c.JobPodTemplate).Should(ContainSubstring("{{ .NodeName }}"))
+		})
+		It("should read the controller settings from env", func() {
+			_ = os.Setenv(config.EnvCallbackServicePort, "1234")
+			_ = os.Setenv(config.EnvReportDirectory, "/tmp")
+			c, err := config.FromBatchJob(bj)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.CallbackServicePort).Should(Equal(1234))
+			Ω(c.ReportDirectory).Should(Equal("/tmp"))
+		})
+		It("should fail with an invalid port", func() {
+			_ = os.Setenv(config.EnvCallbackServicePort, "abc")
+			_, err := config.FromBatchJob(bj)
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should convert each batch job into a job", func() {
+			other := bj.DeepCopy()
+			other.Name = "another-job"
+			other.Spec.Metrics.Prefix = ""
+			c, invalid, err := config.FromBatchJobs("bar", []v1alpha1.BatchJob{*bj, *other})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(invalid).Should(BeEmpty())
+			Ω(c.Validate()).ShouldNot(HaveOccurred())
+			jobs := c.JobConfigs()
+			Ω(jobs).Should(HaveLen(2))
+			Ω(jobs[0].Name).Should(Equal("another-job"))
+			Ω(jobs[0].Metrics.Prefix).Should(Equal("batch_job_another_job"))
+			Ω(jobs[0].ReportDirectory).Should(Equal("/var/www/another-job"))
+			Ω(jobs[0].CallbackServiceName).Should(Equal("svc"))
+			Ω(jobs[1].Name).Should(Equal("foo"))
+			Ω(jobs[1].Metrics.Prefix).Should(Equal("foo"))
+			Ω(jobs[1].ReportDirectory).Should(Equal("/var/www/foo"))
+		})
+		It("should have no jobs without batch jobs", func() {
+			c, _, err := config.FromBatchJobs("bar", nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.JobConfigs()).Should(BeEmpty())
+			Ω(c.Validate()).ShouldNot(HaveOccurred())
+		})
+		It("should use the default pod pool size", func() {
+			bj.Spec.PodPoolSize = 0
+			c, err := config.FromBatchJob(bj)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.PodPoolSize).Should(Equal(config.DefaultPodPoolSize))
+		})
+		It("should skip invalid batch jobs", func() {
+			invalidCron := bj.DeepCopy()
+			invalidCron.Name = "invalid-cron"
+			invalidCron.Spec.CronExpression = "invalid"
+			duplicatePrefix := bj.DeepCopy()
+			duplicatePrefix.Name = "other"
+
+			c, invalid, err := config.FromBatchJobs("bar", []v1alpha1.BatchJob{*bj, *invalidCron, *duplicatePrefix})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.Validate()).ShouldNot(HaveOccurred())
+			jobs := c.JobConfigs()
+			Ω(jobs).Should(HaveLen(1))
+			Ω(jobs[0].Name).Should(Equal("foo"))
+			Ω(invalid).Should(HaveLen(2))
+			Ω(invalid["invalid-cron"].Error()).Should(ContainSubstring("cronExpression"))
+			Ω(invalid["other"].Error()).Should(ContainSubstring("duplicate metrics prefix"))
+		})
 	})
 
 	Context("Get", func() {
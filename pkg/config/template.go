@@ -0,0 +1,28 @@
+package config
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	sigsyaml "github.com/ghodss/yaml"
+)
+
+// ParsePodTemplate parse the pod template of the job with the sprig functions and toYaml
+func (cfg *Config) ParsePodTemplate() (*template.Template, error) {
+	funcs := sprig.TxtFuncMap()
+	// the environment of the controller must not leak into the job pods
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	funcs["toYaml"] = toYaml
+	return template.New("job-pod").Funcs(funcs).Parse(cfg.JobPodTemplate)
+}
+
+// toYaml render a value as yaml without the trailing newline, an empty string is returned if it can not be rendered
+func toYaml(v interface{}) string {
+	b, err := sigsyaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
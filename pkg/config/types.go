@@ -2,43 +2,289 @@ package config
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // Config struct
 type Config struct {
-	Name                  string                 `json:"name"`
+	Name                  string                 `json:"name" validate:"required"`
 	JobServiceAccount     string                 `json:"jobServiceAccount"`
+	Target                Target                 `json:"target"`
 	JobNodeSelector       map[string]string      `json:"jobNodeSelector"`
+	JobNodeLabelSelector  *metav1.LabelSelector  `json:"jobNodeLabelSelector"`
+	ExcludedNodes         []string               `json:"excludedNodes"`
 	RunOnUnscheduledNodes bool                   `json:"runOnUnscheduledNodes"`
-	CronExpression        string                 `json:"cronExpression"`
-	ReportDirectory       string                 `json:"reportDirectory"`
-	ReportHistory         int                    `json:"reportHistory"`
-	PodPoolSize           int                    `json:"podPoolSize"`
+	SkipUntoleratedNodes  bool                   `json:"skipUntoleratedNodes"`
+	CronExpression        string                 `json:"cronExpression" validate:"required,cron"`
+	CronTimeZone          string                 `json:"cronTimeZone" validate:"omitempty,time_zone"`
+	ReportDirectory       string                 `json:"reportDirectory" validate:"required"`
+	ReportHistory         int                    `json:"reportHistory" validate:"min=0"`
+	ExecutionIDFormat     string                 `json:"executionIDFormat" validate:"omitempty,execution_id_format"`
+	PodPoolSize           int                    `json:"podPoolSize" validate:"gt=0"`
 	RunOnStartup          bool                   `json:"runOnStartup"`
+	StartingDeadline      int                    `json:"startingDeadlineSeconds" validate:"min=0"`
+	JobTimeout            metav1.Duration        `json:"jobTimeout"`
+	Retry                 Retry                  `json:"retry"`
+	ConcurrencyPolicy     ConcurrencyPolicy      `json:"concurrencyPolicy" validate:"omitempty,oneof=Allow Forbid Replace"`
 	Metrics               Metrics                `json:"metrics"`
 	Custom                map[string]interface{} `json:"custom"`
-	CallbackServiceName   string                 `json:"callbackServiceName"`
-	CallbackServicePort   int                    `json:"callbackServicePort"`
+	CallbackServiceName   string                 `json:"callbackServiceName" validate:"required"`
+	CallbackServicePort   int                    `json:"callbackServicePort" validate:"min=1,max=65535"`
+	PodTemplate           string                 `json:"podTemplate"`
+	Jobs                  []Config               `json:"jobs" validate:"-"`
 
 	Namespace      string         `json:"-"`
 	JobPodTemplate string         `json:"-"`
 	Owner          runtime.Object `json:"-"`
+
+	unknownFields []string
+	// jobsOnly the config is no job itself, e.g. the config of all batch job resources
+	jobsOnly bool
+}
+
+// PodName get the name of the pod of the target
+// the names of node targets are cut at the first '.' to use the host name of fully qualified node names,
+// the names of other targets, e.g. persistent volumes, are used as they are to keep them unique
+func (cfg *Config) PodName(targetName string, id string) string {
+	if cfg.TargetKind() == TargetKindNode {
+		targetName = strings.Split(targetName, ".")[0]
+	}
+	return fmt.Sprintf("%s-job-%s-%s", cfg.Name, targetName, id)
+}
+
+// JobConfigs get the configs of all jobs.
+// If no jobs are defined, the config itself is the only job, unless it is a config of batch job resources.
+// Jobs inherit the controller settings and undefined job settings from the main config.
+func (cfg *Config) JobConfigs() []*Config {
+	if len(cfg.Jobs) == 0 {
+		if cfg.jobsOnly {
+			return nil
+		}
+		return []*Config{cfg}
+	}
+	var jobs []*Config
+	for i := range cfg.Jobs {
+		j := cfg.Jobs[i]
+		j.Namespace = cfg.Namespace
+		j.Owner = cfg.Owner
+		j.CallbackServiceName = cfg.CallbackServiceName
+		j.CallbackServicePort = cfg.CallbackServicePort
+		j.ReportDirectory = filepath.Join(cfg.ReportDirectory, j.Name)
+		j.Jobs = nil
+
+		if j.JobServiceAccount == "" {
+			j.JobServiceAccount = cfg.JobServiceAccount
+		}
+		if j.Target.Kind == "" {
+			j.Target = cfg.Target
+		}
+		if j.JobNodeSelector == nil {
+			j.JobNodeSelector = cfg.JobNodeSelector
+		}
+		if j.JobNodeLabelSelector == nil {
+			j.JobNodeLabelSelector = cfg.JobNodeLabelSelector
+		}
+		if j.ExcludedNodes == nil {
+			j.ExcludedNodes = cfg.ExcludedNodes
+		}
+		if j.ReportHistory == 0 {
+			j.ReportHistory = cfg.ReportHistory
+		}
+		if j.ExecutionIDFormat == "" {
+			j.ExecutionIDFormat = cfg.ExecutionIDFormat
+		}
+		if j.PodPoolSize == 0 {
+			j.PodPoolSize = cfg.PodPoolSize
+		}
+		if j.JobTimeout.Duration == 0 {
+			j.JobTimeout = cfg.JobTimeout
+		}
+		if j.Retry.MaxAttempts == 0 {
+			j.Retry = cfg.Retry
+		}
+		if j.CronTimeZone == "" {
+			j.CronTimeZone = cfg.CronTimeZone
+		}
+		if j.StartingDeadline == 0 {
+			j.StartingDeadline = cfg.StartingDeadline
+		}
+		if j.ConcurrencyPolicy == "" {
+			j.ConcurrencyPolicy = cfg.ConcurrencyPolicy
+		}
+		if j.Custom == nil {
+			j.Custom = cfg.Custom
+		}
+		if j.Metrics.Prefix == "" {
+			j.Metrics.Prefix = strings.ReplaceAll(fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, j.Name), "-", "_")
+		}
+		jobs = append(jobs, &j)
+	}
+	return jobs
+}
+
+// JobConfig get the config of the job with the given name, nil if not found
+func (cfg *Config) JobConfig(name string) *Config {
+	for _, j := range cfg.JobConfigs() {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// IDFormat get the time layout of the execution ids, DefaultExecutionIDFormat if not defined
+func (cfg *Config) IDFormat() string {
+	if cfg.ExecutionIDFormat == "" {
+		return DefaultExecutionIDFormat
+	}
+	return cfg.ExecutionIDFormat
+}
+
+// Concurrency get the concurrency policy of the job, Allow if not defined
+func (cfg *Config) Concurrency() ConcurrencyPolicy {
+	if cfg.ConcurrencyPolicy == "" {
+		return AllowConcurrent
+	}
+	return cfg.ConcurrencyPolicy
+}
+
+// TargetKind get the kind of the targets to start the job pods for, node if not defined
+func (cfg *Config) TargetKind() string {
+	if cfg.Target.Kind == "" {
+		return TargetKindNode
+	}
+	return cfg.Target.Kind
+}
+
+// NodeSelector get the selector of the nodes to run the job pods on,
+// combining the labels of the jobNodeSelector with the jobNodeLabelSelector
+func (cfg *Config) NodeSelector() (labels.Selector, error) {
+	selector := labels.SelectorFromSet(cfg.JobNodeSelector)
+	if cfg.JobNodeLabelSelector == nil {
+		return selector, nil
+	}
+	ls, err := metav1.LabelSelectorAsSelector(cfg.JobNodeLabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	reqs, _ := ls.Requirements()
+	return selector.Add(reqs...), nil
+}
+
+// IsExcluded check if the node is excluded from the job
+func (cfg *Config) IsExcluded(nodeName string) bool {
+	for _, n := range cfg.ExcludedNodes {
+		if n == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule get the cron expression in the time zone of the job.
+// The time zone is ignored if the expression defines its own with a CRON_TZ= or TZ= prefix
+func (cfg *Config) Schedule() string {
+	if cfg.CronTimeZone == "" || strings.HasPrefix(cfg.CronExpression, "CRON_TZ=") || strings.HasPrefix(cfg.CronExpression, "TZ=") {
+		return cfg.CronExpression
+	}
+	return fmt.Sprintf("CRON_TZ=%s %s", cfg.CronTimeZone, cfg.CronExpression)
+}
+
+// StartingDeadlineDuration get the deadline to start a missed execution, 0 if missed executions are not started
+func (cfg *Config) StartingDeadlineDuration() time.Duration {
+	return time.Duration(cfg.StartingDeadline) * time.Second
 }
 
-// PodName get the name of the pod
-func (cfg *Config) PodName(nodeName string, id string) string {
-	nameParts := strings.Split(nodeName, ".")
-	podName := fmt.Sprintf("%s-job-%s-%s", cfg.Name, nameParts[0], id)
-	return podName
+// Target config of the targets to start the job pods for
+type Target struct {
+	// Kind the kind of the targets: node (default), namespace, persistentVolume or static
+	Kind string `json:"kind" validate:"omitempty,oneof=node namespace persistentVolume static"`
+	// LabelSelector the selector of the namespaces or persistent volumes
+	LabelSelector *metav1.LabelSelector `json:"labelSelector"`
+	// Items the names of the static targets
+	Items []string `json:"items"`
+}
+
+const (
+	// TargetKindNode one pod per node
+	TargetKindNode = "node"
+	// TargetKindNamespace one pod per namespace
+	TargetKindNamespace = "namespace"
+	// TargetKindPersistentVolume one pod per persistent volume
+	TargetKindPersistentVolume = "persistentVolume"
+	// TargetKindStatic one pod per item of a static list
+	TargetKindStatic = "static"
+)
+
+// DefaultExecutionIDFormat the default time layout of the execution ids: yyyyMMddHHmmss
+const DefaultExecutionIDFormat = "20060102150405"
+
+// DefaultPodPoolSize the default number of concurrent job pods of a batch job resource
+const DefaultPodPoolSize = 10
+
+// ConcurrencyPolicy how to treat a new execution while the last execution is still active
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent allows executions to run concurrently
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+	// ForbidConcurrent skips the new execution if the last execution is still active
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// ReplaceConcurrent cancels the active execution and starts the new execution
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// Retry config of failed job pods
+type Retry struct {
+	MaxAttempts     int             `json:"maxAttempts" validate:"min=0"`
+	Backoff         metav1.Duration `json:"backoff"`
+	OnFailure       bool            `json:"onFailure"`
+	OnMissingReport bool            `json:"onMissingReport"`
+}
+
+// ShouldRetry check if a pod of the given attempt should be retried
+func (r *Retry) ShouldRetry(attempt int, failed bool, reportReceived bool) bool {
+	if attempt >= r.MaxAttempts {
+		return false
+	}
+	return (failed && r.OnFailure) || (!reportReceived && r.OnMissingReport)
 }
 
 // Metrics config
 type Metrics struct {
-	Prefix string            `json:"prefix"`
-	Gauges map[string]Metric `json:"gauges"`
+	Prefix     string               `json:"prefix" validate:"required,metric_name"`
+	Gauges     map[string]Metric    `json:"gauges" validate:"dive,keys,metric_name,endkeys,required"`
+	Counters   map[string]Metric    `json:"counters" validate:"dive,keys,metric_name,endkeys,required"`
+	Histograms map[string]Histogram `json:"histograms" validate:"dive,keys,metric_name,endkeys,required"`
+	Info       map[string]Metric    `json:"info" validate:"dive,keys,metric_name,endkeys,required"`
+	// Lenient accept reports with unknown metrics or missing and extra labels
+	Lenient bool `json:"lenient"`
+	// DropExecutionIDLabel expose the metrics without the executionID label, only the latest values per node are kept
+	DropExecutionIDLabel bool `json:"dropExecutionIDLabel"`
+}
+
+const (
+	// MetricTypeGauge the reported value is set
+	MetricTypeGauge = "gauge"
+	// MetricTypeCounter the reported value is added
+	MetricTypeCounter = "counter"
+	// MetricTypeHistogram the reported values are observed
+	MetricTypeHistogram = "histogram"
+	// MetricTypeInfo the reported labels are exposed with the value 1
+	MetricTypeInfo = "info"
+)
+
+// MetricDefinition a metric of any type
+type MetricDefinition struct {
+	Metric
+	Type    string
+	Buckets []float64
 }
 
 // NameFor get the name of a metric
@@ -46,8 +292,39 @@ func (m *Metrics) NameFor(name string) string {
 	return fmt.Sprintf("%s_%s", m.Prefix, name)
 }
 
+// Definitions get the metrics of all types by name
+func (m *Metrics) Definitions() map[string]MetricDefinition {
+	defs := make(map[string]MetricDefinition)
+	for name, metric := range m.Gauges {
+		defs[name] = MetricDefinition{Metric: metric, Type: MetricTypeGauge}
+	}
+	for name, metric := range m.Counters {
+		defs[name] = MetricDefinition{Metric: metric, Type: MetricTypeCounter}
+	}
+	for name, metric := range m.Histograms {
+		defs[name] = MetricDefinition{Metric: metric.Metric, Type: MetricTypeHistogram, Buckets: metric.Buckets}
+	}
+	for name, metric := range m.Info {
+		defs[name] = MetricDefinition{Metric: metric, Type: MetricTypeInfo}
+	}
+	return defs
+}
+
+// Definition get the metric with the given name, false if not defined
+func (m *Metrics) Definition(name string) (MetricDefinition, bool) {
+	def, ok := m.Definitions()[name]
+	return def, ok
+}
+
 // Metric config
 type Metric struct {
 	Help   string   `json:"help"`
-	Labels []string `json:"labels"`
+	Labels []string `json:"labels" validate:"dive,label_name"`
+}
+
+// Histogram config
+type Histogram struct {
+	Metric `json:",inline"`
+	// Buckets the upper bounds of the buckets in increasing order, the prometheus default buckets are used if empty
+	Buckets []float64 `json:"buckets"`
 }
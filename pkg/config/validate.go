@@ -0,0 +1,336 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	sigsyaml "github.com/ghodss/yaml"
+	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/common/model"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Validate check the config and all its jobs, every problem found is reported in the returned error
+func (cfg *Config) Validate() error {
+	problems := append([]string{}, cfg.unknownFields...)
+
+	validate := newValidator()
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, jc := range cfg.JobConfigs() {
+		job := jc.Name
+		if names[job] {
+			problems = append(problems, fmt.Sprintf("job %q: duplicate job name", job))
+		}
+		names[job] = true
+		if prefixes[jc.Metrics.Prefix] {
+			problems = append(problems, fmt.Sprintf("job %q: duplicate metrics prefix %q", job, jc.Metrics.Prefix))
+		}
+		prefixes[jc.Metrics.Prefix] = true
+
+		if err := validate.Struct(jc); err != nil {
+			if ve, ok := err.(validator.ValidationErrors); ok {
+				for _, fe := range ve {
+					problems = append(problems, fmt.Sprintf("job %q: invalid value '%v' of field %q: failed on the %q check",
+						job, fe.Value(), fe.Namespace(), fe.Tag()))
+				}
+			} else {
+				problems = append(problems, fmt.Sprintf("job %q: %v", job, err))
+			}
+		}
+
+		if _, err := jc.NodeSelector(); err != nil {
+			problems = append(problems, fmt.Sprintf("job %q: invalid jobNodeLabelSelector: %v", job, err))
+		}
+		if jc.Target.LabelSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(jc.Target.LabelSelector); err != nil {
+				problems = append(problems, fmt.Sprintf("job %q: invalid target.labelSelector: %v", job, err))
+			}
+		}
+		if jc.TargetKind() == TargetKindStatic {
+			if len(jc.Target.Items) == 0 {
+				problems = append(problems, fmt.Sprintf("job %q: target.items are required for static targets", job))
+			}
+			// the items are used in the pod names and labels
+			for _, item := range jc.Target.Items {
+				if errs := validation.IsDNS1123Label(item); len(errs) > 0 {
+					problems = append(problems, fmt.Sprintf("job %q: invalid target item %q: %s", job, item, strings.Join(errs, ", ")))
+				}
+			}
+		}
+
+		for _, p := range metricProblems(&jc.Metrics) {
+			problems = append(problems, fmt.Sprintf("job %q: %s", job, p))
+		}
+
+		if err := validatePodTemplate(jc); err != nil {
+			problems = append(problems, fmt.Sprintf("job %q: invalid pod template: %v", job, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n - %s", strings.Join(problems, "\n - "))
+	}
+	return nil
+}
+
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return jsonName(f)
+	})
+	_ = validate.RegisterValidation("cron", isCron)
+	_ = validate.RegisterValidation("time_zone", isTimeZone)
+	_ = validate.RegisterValidation("execution_id_format", isExecutionIDFormat)
+	_ = validate.RegisterValidation("metric_name", isMetricName)
+	_ = validate.RegisterValidation("label_name", isLabelName)
+	return validate
+}
+
+func isCron(fl validator.FieldLevel) bool {
+	_, err := cron.ParseStandard(fl.Field().String())
+	return err == nil
+}
+
+func isTimeZone(fl validator.FieldLevel) bool {
+	_, err := time.LoadLocation(fl.Field().String())
+	return err == nil
+}
+
+// isExecutionIDFormat check if the time layout creates ids that change over time, can be parsed again
+// and can be used in pod names and label values, including the suffix added on collisions
+func isExecutionIDFormat(fl validator.FieldLevel) bool {
+	format := fl.Field().String()
+	t := time.Date(2020, time.December, 31, 23, 59, 58, 0, time.Local)
+	id := t.Format(format)
+	if id == t.AddDate(-1, -1, -1).Add(-time.Hour-time.Minute-time.Second).Format(format) {
+		return false
+	}
+	if _, err := time.ParseInLocation(format, id, time.Local); err != nil {
+		return false
+	}
+	return len(validation.IsDNS1123Label(id+"-99")) == 0
+}
+
+func isMetricName(fl validator.FieldLevel) bool {
+	return model.IsValidMetricName(model.LabelValue(fl.Field().String()))
+}
+
+func isLabelName(fl validator.FieldLevel) bool {
+	return model.LabelName(fl.Field().String()).IsValid()
+}
+
+// validatePodTemplate render the pod template with sample data and decode it strictly as pod
+func validatePodTemplate(cfg *Config) error {
+	tmpl, err := cfg.ParsePodTemplate()
+	if err != nil {
+		return err
+	}
+
+	var node interface{}
+	if cfg.TargetKind() == TargetKindNode {
+		node = map[string]interface{}{
+			"Name":        "validate",
+			"Labels":      map[string]string{},
+			"Annotations": map[string]string{},
+			"Capacity":    map[string]string{},
+			"Addresses":   map[string]string{},
+		}
+	}
+	url := fmt.Sprintf("http://validate:%d/report/%s/validate/validate", cfg.CallbackServicePort, cfg.Name)
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"Namespace":   cfg.Namespace,
+		"ExecutionID": "validate",
+		"Attempt":     1,
+		"NodeName":    "validate",
+		"Node":        node,
+		"Target": map[string]interface{}{
+			"Name":        "validate",
+			"Kind":        cfg.TargetKind(),
+			"NodeName":    "validate",
+			"Labels":      map[string]string{},
+			"Annotations": map[string]string{},
+			"Data":        map[string]string{},
+		},
+		"Custom": cfg.Custom,
+		"Callback": map[string]string{
+			"ServiceName": "validate",
+			"ServicePort": fmt.Sprintf("%d", cfg.CallbackServicePort),
+			"ResultURL":   url + "/result",
+			"FileURL":     url + "/file",
+			"EventURL":    url + "/event",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	j, err := sigsyaml.YAMLToJSON(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.DisallowUnknownFields()
+	pod := &corev1.Pod{}
+	if err := decoder.Decode(pod); err != nil {
+		return err
+	}
+	if pod.Kind != "" && pod.Kind != "Pod" {
+		return fmt.Errorf("unexpected kind %q", pod.Kind)
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("no containers defined")
+	}
+	return nil
+}
+
+// metricProblems check that each metric name is used by a single type and the buckets of the histograms are increasing
+func metricProblems(m *Metrics) []string {
+	var problems []string
+	types := make(map[string][]string)
+	add := func(metricType string, names []string) {
+		for _, name := range names {
+			types[name] = append(types[name], metricType)
+		}
+	}
+	add(MetricTypeGauge, metricNames(m.Gauges))
+	add(MetricTypeCounter, metricNames(m.Counters))
+	var histograms []string
+	for name := range m.Histograms {
+		histograms = append(histograms, name)
+	}
+	add(MetricTypeHistogram, histograms)
+	add(MetricTypeInfo, metricNames(m.Info))
+
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(types[name]) > 1 {
+			problems = append(problems, fmt.Sprintf("metric %q is defined with multiple types: %s", name, strings.Join(types[name], ", ")))
+		}
+	}
+
+	sort.Strings(histograms)
+	for _, name := range histograms {
+		buckets := m.Histograms[name].Buckets
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				problems = append(problems, fmt.Sprintf("the buckets of histogram %q must be in increasing order", name))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func metricNames(metrics map[string]Metric) []string {
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	return names
+}
+
+// decodeStrict decode the yaml into the config and record all fields that are not known
+func decodeStrict(data string, cfg *Config) error {
+	j, err := sigsyaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(j, cfg); err != nil {
+		return err
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(j, &raw); err != nil {
+		return err
+	}
+	cfg.unknownFields = unknownFields("", raw, reflect.TypeOf(*cfg))
+	return nil
+}
+
+// unknownFields walk the raw value along the given type and collect all keys that have no matching field
+func unknownFields(path string, raw interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var unknown []string
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := make(map[string]reflect.Type)
+			jsonFields(t, fields)
+			keys := sortedKeys(v)
+			for _, k := range keys {
+				ft, ok := fields[k]
+				if !ok {
+					unknown = append(unknown, fmt.Sprintf("unknown field %q", join(path, k)))
+					continue
+				}
+				unknown = append(unknown, unknownFields(join(path, k), v[k], ft)...)
+			}
+		case reflect.Map:
+			for _, k := range sortedKeys(v) {
+				unknown = append(unknown, unknownFields(join(path, k), v[k], t.Elem())...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i := range v {
+				unknown = append(unknown, unknownFields(fmt.Sprintf("%s[%d]", path, i), v[i], t.Elem())...)
+			}
+		}
+	}
+	return unknown
+}
+
+// jsonFields collect the json fields of the struct type including the fields of embedded structs
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name := jsonName(f); name != "" {
+			fields[name] = f.Type
+		} else if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields)
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	if name == "-" || f.PkgPath != "" {
+		return ""
+	}
+	return name
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,297 @@
+package config_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	validConfig = `
+name: foo
+cronExpression: "42 3 * * *"
+reportDirectory: /var/www
+podPoolSize: 3
+callbackServiceName: svc
+callbackServicePort: 8090
+metrics:
+  prefix: foo
+  gauges:
+    test:
+      help: help
+      labels:
+        - label_a
+`
+	validPodTemplate = `
+kind: Pod
+spec:
+  containers:
+    - name: job
+      image: "{{ .NodeName }}"
+`
+)
+
+var _ = Describe("Validate", func() {
+	var (
+		cm *corev1.ConfigMap
+	)
+	BeforeEach(func() {
+		cm = &corev1.ConfigMap{
+			Data: map[string]string{
+				config.ConfigFileName:  validConfig,
+				config.PodTemplateName: validPodTemplate,
+			},
+		}
+	})
+	It("should accept a valid config", func() {
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should read the job timeout", func() {
+		cm.Data[config.ConfigFileName] = validConfig + "jobTimeout: 1h30m"
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+		Ω(cfg.JobTimeout.Duration).Should(Equal(90 * time.Minute))
+	})
+	It("should accept a time zone prefix in the cron expression", func() {
+		cm.Data[config.ConfigFileName] = strings.Replace(validConfig, `"42 3 * * *"`, `"CRON_TZ=Europe/Zurich 42 3 * * *"`, 1)
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should accept an execution id format", func() {
+		cm.Data[config.ConfigFileName] = validConfig + "executionIDFormat: 20060102-150405"
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+		Ω(cfg.IDFormat()).Should(Equal("20060102-150405"))
+	})
+	It("should reject execution id formats that are not unique or no valid pod name", func() {
+		for _, format := range []string{"static", "Jan 2 15:04:05", "2006-01-02T15:04:05Z07:00"} {
+			cm.Data[config.ConfigFileName] = validConfig + "executionIDFormat: " + format
+			cfg, err := config.FromConfigMap("ns", cm)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = cfg.Validate()
+			Ω(err).Should(HaveOccurred(), format)
+			Ω(err.Error()).Should(ContainSubstring(`"Config.executionIDFormat": failed on the "execution_id_format" check`))
+		}
+	})
+	It("should accept valid jobs", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+jobs:
+  - name: a
+    cronExpression: "* * * * *"
+  - name: b
+    cronExpression: "0 * * * *"
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should report all problems", func() {
+		cm.Data[config.ConfigFileName] = `
+name: foo
+cronExpression: "invalid"
+reportDirectory: /var/www
+podPoolSize: 0
+callbackServiceName: svc
+callbackServicePort: 8090
+unknown: true
+concurrencyPolicy: Sometimes
+cronTimeZone: Mars/Olympus
+jobNodeLabelSelector:
+  matchExpressions:
+    - key: role
+      operator: Maybe
+metrics:
+  prefix: "1foo"
+  gauges:
+    test:
+      help: help
+      foo: bar
+      labels:
+        - label-a
+`
+		cm.Data[config.PodTemplateName] = "kind: Pod\nspec:\n  foo: bar"
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring(`unknown field "unknown"`))
+		Ω(err.Error()).Should(ContainSubstring(`unknown field "metrics.gauges.test.foo"`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.cronExpression": failed on the "cron" check`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.podPoolSize": failed on the "gt" check`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.concurrencyPolicy": failed on the "oneof" check`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.cronTimeZone": failed on the "time_zone" check`))
+		Ω(err.Error()).Should(ContainSubstring(`invalid jobNodeLabelSelector: "Maybe" is not a valid pod selector operator`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.metrics.prefix": failed on the "metric_name" check`))
+		Ω(err.Error()).Should(ContainSubstring(`"Config.metrics.gauges[test].labels[0]": failed on the "label_name" check`))
+		Ω(err.Error()).Should(ContainSubstring(`invalid pod template: json: unknown field "foo"`))
+	})
+	It("should report unknown fields and problems of jobs", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+jobs:
+  - name: a
+    cronExpression: "* * * * *"
+    foo: bar
+  - name: a
+    cronExpression: "* * * * *"
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring(`unknown field "jobs[0].foo"`))
+		Ω(err.Error()).Should(ContainSubstring(`job "a": duplicate job name`))
+		Ω(err.Error()).Should(ContainSubstring(`job "a": duplicate metrics prefix "foo_a"`))
+	})
+	It("should render the target in the pod template", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+target:
+  kind: persistentVolume
+`
+		cm.Data[config.PodTemplateName] = validPodTemplate + `  volumes:
+    - name: data
+      persistentVolumeClaim:
+        claimName: '{{ index .Target.Data "claimName" }}'
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should accept template functions and the template data", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+custom:
+  image: busybox
+`
+		cm.Data[config.PodTemplateName] = `
+kind: Pod
+metadata:
+  annotations:
+    zone: {{ .Node.Labels.zone | default "none" | quote }}
+    result: {{ .Callback.ResultURL | quote }}
+spec:
+  containers:
+    - name: job
+      image: {{ .Custom.image }}
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should require the items of static targets", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+target:
+  kind: static
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("target.items are required for static targets"))
+	})
+	It("should accept static targets with valid items", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+target:
+  kind: static
+  items:
+    - db-1
+    - db-2
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+	})
+	It("should report static target items that are no DNS-1123 labels", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+target:
+  kind: static
+  items:
+    - db-1
+    - DB_2
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring(`invalid target item "DB_2"`))
+		Ω(err.Error()).ShouldNot(ContainSubstring(`"db-1"`))
+	})
+	It("should report a pod template without containers", func() {
+		cm.Data[config.PodTemplateName] = "kind: Pod"
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("no containers defined"))
+	})
+	It("should not provide the env functions in the pod template", func() {
+		for _, fn := range []string{`env "API_TOKEN"`, `expandenv "$API_TOKEN"`} {
+			cm.Data[config.PodTemplateName] = validPodTemplate + `
+      env:
+        - name: TOKEN
+          value: "{{ ` + fn + ` }}"
+`
+			cfg, err := config.FromConfigMap("ns", cm)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = cfg.ParsePodTemplate()
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("not defined"))
+			Ω(cfg.Validate()).Should(HaveOccurred())
+		}
+	})
+	It("should accept counters, histograms and info metrics", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+  counters:
+    events:
+      help: help
+  histograms:
+    latency:
+      help: help
+      labels:
+        - label_a
+      buckets: [0.1, 0.5, 1]
+  info:
+    version:
+      labels:
+        - version
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cfg.Validate()).ShouldNot(HaveOccurred())
+		Ω(cfg.Metrics.Histograms["latency"].Labels).Should(Equal([]string{"label_a"}))
+		Ω(cfg.Metrics.Histograms["latency"].Buckets).Should(Equal([]float64{0.1, 0.5, 1}))
+
+		def, ok := cfg.Metrics.Definition("latency")
+		Ω(ok).Should(BeTrue())
+		Ω(def.Type).Should(Equal(config.MetricTypeHistogram))
+	})
+	It("should report metrics with multiple types and unordered buckets", func() {
+		cm.Data[config.ConfigFileName] = validConfig + `
+  counters:
+    test: {}
+  histograms:
+    latency:
+      buckets: [1, 0.5]
+`
+		cfg, err := config.FromConfigMap("ns", cm)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		err = cfg.Validate()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring(`metric "test" is defined with multiple types: gauge, counter`))
+		Ω(err.Error()).Should(ContainSubstring(`the buckets of histogram "latency" must be in increasing order`))
+	})
+})
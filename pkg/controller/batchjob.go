@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bakito/batch-job-controller/api/v1alpha1"
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/inject"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	"github.com/go-logr/logr"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// BatchJobReconciler reconciler for the batch job resources, each resource is a job of the config
+type BatchJobReconciler struct {
+	client.Client
+	Log       logr.Logger
+	Namespace string
+	Config    *config.Config
+	Targets   []inject.Config
+	lock      sync.Mutex
+
+	// the latest status of each job, written to the batch job resource by the next reconcile
+	statuses    map[string]lifecycle.ExecutionStatus
+	statusLock  sync.Mutex
+	statusQueue chan event.GenericEvent
+	initOnce    sync.Once
+}
+
+// verify interface is implemented
+var _ lifecycle.Listener = &BatchJobReconciler{}
+
+// SetupWithManager setup
+func (r *BatchJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.init()
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.BatchJob{}).
+		Watches(&source.Channel{Source: r.statusQueue}, &handler.EnqueueRequestForObject{}).
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Complete(r)
+}
+
+func (r *BatchJobReconciler) init() {
+	r.initOnce.Do(func() {
+		r.statuses = make(map[string]lifecycle.ExecutionStatus)
+		r.statusQueue = make(chan event.GenericEvent)
+	})
+}
+
+// Reconcile write the pending status of the batch job and apply a changed config of all batch jobs to all targets
+func (r *BatchJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	bjLog := r.Log.WithValues("batchjob", req.NamespacedName)
+
+	if err := r.updateStatus(ctx, req.NamespacedName); err != nil {
+		bjLog.Error(err, "could not update status")
+		return reconcile.Result{}, err
+	}
+
+	bjs := &v1alpha1.BatchJobList{}
+	if err := r.List(ctx, bjs, client.InNamespace(r.Namespace)); err != nil {
+		bjLog.Error(err, "unexpected error")
+		return reconcile.Result{}, err
+	}
+
+	cfg, invalid, err := config.FromBatchJobs(r.Namespace, bjs.Items)
+	if err != nil {
+		// do not requeue, the controller settings have to be fixed first
+		bjLog.Error(err, "could not read config, keeping current config")
+		return reconcile.Result{}, nil
+	}
+
+	r.lock.Lock()
+	r.Config = applyConfig(bjLog, r.Config, cfg, r.Targets)
+	r.lock.Unlock()
+
+	if err := r.updateErrors(ctx, bjs.Items, invalid); err != nil {
+		bjLog.Error(err, "could not update status")
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// updateErrors write the validation error of each batch job into its status, the batch jobs with an error are ignored
+func (r *BatchJobReconciler) updateErrors(ctx context.Context, bjs []v1alpha1.BatchJob, invalid map[string]error) error {
+	for i := range bjs {
+		bj := &bjs[i]
+		msg := ""
+		if err, ok := invalid[bj.Name]; ok {
+			msg = err.Error()
+		}
+		if bj.Status.Error == msg {
+			continue
+		}
+		if msg != "" {
+			r.Log.WithValues("batchjob", bj.Name).Info("ignoring invalid batch job", "error", msg)
+		}
+		bj.Status.Error = msg
+		if err := r.Status().Update(ctx, bj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatusChanged remember the execution status and enqueue a reconcile of the batch job to write it
+func (r *BatchJobReconciler) StatusChanged(status lifecycle.ExecutionStatus) {
+	r.init()
+	r.statusLock.Lock()
+	_, queued := r.statuses[status.Job]
+	r.statuses[status.Job] = status
+	r.statusLock.Unlock()
+
+	if !queued {
+		// do not block the caller until the controller takes the event
+		go func() {
+			r.statusQueue <- event.GenericEvent{
+				Meta:   &metav1.ObjectMeta{Namespace: r.Namespace, Name: status.Job},
+				Object: &v1alpha1.BatchJob{},
+			}
+		}()
+	}
+}
+
+// updateStatus write the pending status into the batch job, the status is kept for the retry if the update fails
+func (r *BatchJobReconciler) updateStatus(ctx context.Context, key client.ObjectKey) error {
+	r.init()
+	r.statusLock.Lock()
+	status, ok := r.statuses[key.Name]
+	delete(r.statuses, key.Name)
+	r.statusLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	bj := &v1alpha1.BatchJob{}
+	err := r.Get(ctx, key, bj)
+	if err == nil {
+		bj.Status.LastExecutionID = status.ExecutionID
+		bj.Status.PodsStarted = status.PodsStarted
+		bj.Status.PodsSucceeded = status.PodsSucceeded
+		bj.Status.PodsFailed = status.PodsFailed
+		if status.LastReportTime != nil {
+			t := metav1.NewTime(*status.LastReportTime)
+			bj.Status.LastReportTime = &t
+		} else {
+			bj.Status.LastReportTime = nil
+		}
+		err = r.Status().Update(ctx, bj)
+	}
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			// the batch job was deleted
+			return nil
+		}
+		r.statusLock.Lock()
+		if _, newer := r.statuses[key.Name]; !newer {
+			r.statuses[key.Name] = status
+		}
+		r.statusLock.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bakito/batch-job-controller/api/v1alpha1"
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/inject"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	mock_logr "github.com/bakito/batch-job-controller/pkg/mocks/logr"
+	gm "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("BatchJob", func() {
+	var (
+		r          *BatchJobReconciler
+		mockCtrl   *gm.Controller //gomock struct
+		mockClient *mock_client.MockClient
+		mockLog    *mock_logr.MockLogger
+		target     *configTarget
+	)
+	BeforeEach(func() {
+		mockCtrl = gm.NewController(GinkgoT())
+		mockClient = mock_client.NewMockClient(mockCtrl)
+		mockLog = mock_logr.NewMockLogger(mockCtrl)
+		target = &configTarget{}
+		r = &BatchJobReconciler{
+			Client:    mockClient,
+			Log:       mockLog,
+			Namespace: "bar",
+			Config: &config.Config{
+				Name:           "foo",
+				CronExpression: "* * * * *",
+			},
+			Targets: []inject.Config{target},
+		}
+	})
+	Context("Reconcile", func() {
+		BeforeEach(func() {
+			_ = os.Setenv(config.EnvCallbackServiceName, "svc")
+			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog)
+		})
+		It("should apply the config of all batch jobs", func() {
+			mockLog.EXPECT().Info("config reloaded")
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJobList{}), client.InNamespace("bar")).
+				Do(func(ctx context.Context, list *v1alpha1.BatchJobList, opts ...client.ListOption) error {
+					list.Items = []v1alpha1.BatchJob{batchJob("foo", "0 * * * *"), batchJob("bar", "30 * * * *")}
+					return nil
+				})
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Requeue).Should(BeFalse())
+			Ω(target.cfg).ShouldNot(BeNil())
+			jobs := target.cfg.JobConfigs()
+			Ω(jobs).Should(HaveLen(2))
+			Ω(jobs[0].Name).Should(Equal("bar"))
+			Ω(jobs[0].CronExpression).Should(Equal("30 * * * *"))
+			Ω(jobs[0].Metrics.Prefix).Should(Equal("batch_job_bar"))
+			Ω(jobs[1].Name).Should(Equal("foo"))
+			Ω(jobs[1].CronExpression).Should(Equal("0 * * * *"))
+			Ω(jobs[1].PodPoolSize).Should(Equal(5))
+			Ω(jobs[1].JobPodTemplate).Should(ContainSubstring("kind: Pod"))
+		})
+		It("should remove the jobs of deleted batch jobs", func() {
+			mockLog.EXPECT().Info("config reloaded")
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJobList{}), client.InNamespace("bar"))
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg).ShouldNot(BeNil())
+			Ω(target.cfg.JobConfigs()).Should(BeEmpty())
+		})
+		It("should skip an invalid batch job and write the error into its status", func() {
+			mockStatus := mock_client.NewMockStatusWriter(mockCtrl)
+			mockLog.EXPECT().Info("config reloaded")
+			mockLog.EXPECT().WithValues("batchjob", "foo").Return(mockLog)
+			mockLog.EXPECT().Info("ignoring invalid batch job", "error", gm.Any())
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJobList{}), client.InNamespace("bar")).
+				Do(func(ctx context.Context, list *v1alpha1.BatchJobList, opts ...client.ListOption) error {
+					list.Items = []v1alpha1.BatchJob{batchJob("foo", "invalid"), batchJob("bar", "30 * * * *")}
+					return nil
+				})
+			mockClient.EXPECT().Status().Return(mockStatus)
+			mockStatus.EXPECT().Update(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJob{})).
+				Do(func(ctx context.Context, bj *v1alpha1.BatchJob, opts ...client.UpdateOption) error {
+					Ω(bj.Name).Should(Equal("foo"))
+					Ω(bj.Status.Error).Should(ContainSubstring("cronExpression"))
+					return nil
+				})
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg).ShouldNot(BeNil())
+			jobs := target.cfg.JobConfigs()
+			Ω(jobs).Should(HaveLen(1))
+			Ω(jobs[0].Name).Should(Equal("bar"))
+		})
+		It("should clear the error of a fixed batch job", func() {
+			mockStatus := mock_client.NewMockStatusWriter(mockCtrl)
+			mockLog.EXPECT().Info("config reloaded")
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJobList{}), client.InNamespace("bar")).
+				Do(func(ctx context.Context, list *v1alpha1.BatchJobList, opts ...client.ListOption) error {
+					bj := batchJob("foo", "0 * * * *")
+					bj.Status.Error = "invalid"
+					list.Items = []v1alpha1.BatchJob{bj}
+					return nil
+				})
+			mockClient.EXPECT().Status().Return(mockStatus)
+			mockStatus.EXPECT().Update(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJob{})).
+				Do(func(ctx context.Context, bj *v1alpha1.BatchJob, opts ...client.UpdateOption) error {
+					Ω(bj.Status.Error).Should(BeEmpty())
+					return nil
+				})
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg.JobConfigs()).Should(HaveLen(1))
+		})
+	})
+	Context("StatusChanged", func() {
+		var (
+			mockStatus *mock_client.MockStatusWriter
+			status     lifecycle.ExecutionStatus
+		)
+		BeforeEach(func() {
+			mockStatus = mock_client.NewMockStatusWriter(mockCtrl)
+			now := time.Now()
+			status = lifecycle.ExecutionStatus{
+				Job:            "foo",
+				ExecutionID:    "id",
+				PodsStarted:    3,
+				PodsSucceeded:  2,
+				PodsFailed:     1,
+				LastReportTime: &now,
+			}
+			r.init()
+		})
+		It("should enqueue the batch job without accessing the api", func() {
+			r.StatusChanged(status)
+			r.StatusChanged(status)
+
+			var e event.GenericEvent
+			Eventually(r.statusQueue).Should(Receive(&e))
+			Ω(e.Meta.GetNamespace()).Should(Equal("bar"))
+			Ω(e.Meta.GetName()).Should(Equal("foo"))
+			// the status is only enqueued once until it is written
+			Consistently(r.statusQueue, "100ms").ShouldNot(Receive())
+		})
+		It("should update the status on reconcile", func() {
+			r.StatusChanged(status)
+			Eventually(r.statusQueue).Should(Receive())
+
+			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog)
+			mockClient.EXPECT().Get(gm.Any(), client.ObjectKey{Namespace: "bar", Name: "foo"}, gm.AssignableToTypeOf(&v1alpha1.BatchJob{}))
+			mockClient.EXPECT().Status().Return(mockStatus)
+			mockStatus.EXPECT().Update(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJob{})).
+				Do(func(ctx context.Context, bj *v1alpha1.BatchJob, opts ...client.UpdateOption) error {
+					Ω(bj.Status.LastExecutionID).Should(Equal("id"))
+					Ω(bj.Status.PodsStarted).Should(Equal(3))
+					Ω(bj.Status.PodsSucceeded).Should(Equal(2))
+					Ω(bj.Status.PodsFailed).Should(Equal(1))
+					Ω(bj.Status.LastReportTime).ShouldNot(BeNil())
+					return nil
+				})
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJobList{}), client.InNamespace("bar")).
+				Return(fmt.Errorf("stop"))
+			mockLog.EXPECT().Error(gm.Any(), gm.Any())
+
+			_, _ = r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(r.statuses).Should(BeEmpty())
+		})
+		It("should keep the status if the update fails", func() {
+			r.StatusChanged(status)
+			Eventually(r.statusQueue).Should(Receive())
+
+			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog)
+			mockLog.EXPECT().Error(gm.Any(), "could not update status")
+			mockClient.EXPECT().Get(gm.Any(), client.ObjectKey{Namespace: "bar", Name: "foo"}, gm.AssignableToTypeOf(&v1alpha1.BatchJob{}))
+			mockClient.EXPECT().Status().Return(mockStatus)
+			mockStatus.EXPECT().Update(gm.Any(), gm.AssignableToTypeOf(&v1alpha1.BatchJob{})).Return(fmt.Errorf("conflict"))
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "bar", Name: "foo"}})
+			Ω(err).Should(HaveOccurred())
+			Ω(r.statuses).Should(HaveKeyWithValue("foo", status))
+		})
+		It("should drop the status of a deleted batch job", func() {
+			r.StatusChanged(status)
+			Eventually(r.statusQueue).Should(Receive())
+
+			mockClient.EXPECT().Get(gm.Any(), client.ObjectKey{Namespace: "bar", Name: "foo"}, gm.AssignableToTypeOf(&v1alpha1.BatchJob{})).
+				Return(k8serrors.NewNotFound(v1alpha1.GroupVersion.WithResource("batchjobs").GroupResource(), "foo"))
+
+			Ω(r.updateStatus(context.Background(), client.ObjectKey{Namespace: "bar", Name: "foo"})).ShouldNot(HaveOccurred())
+			Ω(r.statuses).Should(BeEmpty())
+		})
+	})
+})
+
+func batchJob(name string, cron string) v1alpha1.BatchJob {
+	bj := v1alpha1.BatchJob{}
+	bj.Name = name
+	bj.Spec.CronExpression = cron
+	bj.Spec.PodPoolSize = 5
+	bj.Spec.Template.Spec.Containers = []corev1.Container{{Name: "job", Image: "busybox"}}
+	return bj
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/inject"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ConfigMapReconciler reconciler for the controller configmap
+type ConfigMapReconciler struct {
+	client.Client
+	Log     logr.Logger
+	Name    string
+	Config  *config.Config
+	Targets []inject.Config
+	lock    sync.Mutex
+}
+
+// SetupWithManager setup
+func (r *ConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}).
+		WithEventFilter(&namePredicate{name: r.Name}).
+		Complete(r)
+}
+
+// Reconcile reconcile the configmap and apply a changed config to all targets
+func (r *ConfigMapReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	cmLog := r.Log.WithValues("configmap", req.NamespacedName)
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, req.NamespacedName, cm)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			// keep the current config if the configmap was deleted
+			return reconcile.Result{}, nil
+		}
+
+		cmLog.Error(err, "unexpected error")
+		return reconcile.Result{}, err
+	}
+
+	cfg, err := config.FromConfigMap(req.Namespace, cm)
+	if err != nil {
+		// do not requeue, the configmap has to be fixed first
+		cmLog.Error(err, "could not read config, keeping current config")
+		return reconcile.Result{}, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Config = applyConfig(cmLog, r.Config, cfg, r.Targets)
+	return reconcile.Result{}, nil
+}
+
+// applyConfig validate the new config and inject it into all targets if it has changed
+// returns the config that is active afterwards
+func applyConfig(l logr.Logger, current *config.Config, cfg *config.Config, targets []inject.Config) *config.Config {
+	cfg.Owner = current.Owner
+	if reflect.DeepEqual(cfg, current) {
+		return current
+	}
+
+	if err := validate(cfg); err != nil {
+		l.Error(err, "config is invalid, keeping current config")
+		return current
+	}
+
+	for _, t := range targets {
+		t.InjectConfig(cfg)
+	}
+	l.Info("config reloaded")
+	return cfg
+}
+
+func validate(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for _, jc := range cfg.JobConfigs() {
+		if err := lifecycle.ValidateMetrics(jc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namePredicate filter the resource by name, deletions are ignored to keep the current config
+type namePredicate struct {
+	name string
+}
+
+func (p namePredicate) Create(e event.CreateEvent) bool {
+	return e.Meta.GetName() == p.name
+}
+
+func (p namePredicate) Update(e event.UpdateEvent) bool {
+	return e.MetaNew.GetName() == p.name
+}
+
+func (p namePredicate) Delete(_ event.DeleteEvent) bool {
+	return false
+}
+
+func (p namePredicate) Generic(e event.GenericEvent) bool {
+	return e.Meta.GetName() == p.name
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/inject"
+	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	mock_logr "github.com/bakito/batch-job-controller/pkg/mocks/logr"
+	gm "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	validConfig      = "name: foo\ncallbackServiceName: svc\ncallbackServicePort: 8090\nreportDirectory: /tmp\npodPoolSize: 1\nmetrics:\n  prefix: foo\n"
+	validPodTemplate = "kind: Pod\nspec:\n  containers:\n  - name: job\n    image: busybox"
+)
+
+var _ = Describe("ConfigMap", func() {
+	Context("namePredicate", func() {
+		var (
+			m1 metav1.Object
+			m2 metav1.Object
+			p  *namePredicate
+		)
+		BeforeEach(func() {
+			m1 = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}
+			m2 = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "bar"}}
+			p = &namePredicate{name: "foo"}
+		})
+		It("should match", func() {
+			Ω(p.Create(event.CreateEvent{Meta: m1})).Should(BeTrue())
+			Ω(p.Update(event.UpdateEvent{MetaNew: m1})).Should(BeTrue())
+			Ω(p.Generic(event.GenericEvent{Meta: m1})).Should(BeTrue())
+		})
+		It("should not match", func() {
+			Ω(p.Create(event.CreateEvent{Meta: m2})).Should(BeFalse())
+			Ω(p.Update(event.UpdateEvent{MetaNew: m2})).Should(BeFalse())
+			Ω(p.Delete(event.DeleteEvent{Meta: m1})).Should(BeFalse())
+			Ω(p.Generic(event.GenericEvent{Meta: m2})).Should(BeFalse())
+		})
+	})
+
+	Context("Reconcile", func() {
+		var (
+			r          *ConfigMapReconciler
+			mockCtrl   *gm.Controller //gomock struct
+			mockClient *mock_client.MockClient
+			mockLog    *mock_logr.MockLogger
+			target     *configTarget
+			cfg        *config.Config
+		)
+		BeforeEach(func() {
+			mockCtrl = gm.NewController(GinkgoT())
+			mockClient = mock_client.NewMockClient(mockCtrl)
+			mockLog = mock_logr.NewMockLogger(mockCtrl)
+			target = &configTarget{}
+			cfg = &config.Config{
+				Name:           "foo",
+				CronExpression: "* * * * *",
+				JobPodTemplate: "kind: Pod",
+			}
+			r = &ConfigMapReconciler{
+				Client:  mockClient,
+				Log:     mockLog,
+				Config:  cfg,
+				Targets: []inject.Config{target},
+			}
+			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog)
+		})
+		It("should apply a changed config", func() {
+			mockLog.EXPECT().Info("config reloaded")
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.ConfigMap{})).
+				Do(func(ctx context.Context, key client.ObjectKey, cm *corev1.ConfigMap) error {
+					cm.Data = map[string]string{
+						config.ConfigFileName:  validConfig + "cronExpression: 0 * * * *",
+						config.PodTemplateName: validPodTemplate,
+					}
+					return nil
+				})
+
+			result, err := r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Requeue).Should(BeFalse())
+			Ω(target.cfg).ShouldNot(BeNil())
+			Ω(target.cfg.CronExpression).Should(Equal("0 * * * *"))
+			Ω(r.Config).Should(Equal(target.cfg))
+		})
+		It("should not apply an unchanged config", func() {
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.ConfigMap{})).
+				Do(func(ctx context.Context, key client.ObjectKey, cm *corev1.ConfigMap) error {
+					cm.Data = map[string]string{
+						config.ConfigFileName:  "name: foo\ncronExpression: '* * * * *'",
+						config.PodTemplateName: "kind: Pod",
+					}
+					return nil
+				})
+
+			_, err := r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg).Should(BeNil())
+		})
+		It("should keep the current config if the new one is invalid", func() {
+			mockLog.EXPECT().Error(gm.Any(), gm.Any())
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.ConfigMap{})).
+				Do(func(ctx context.Context, key client.ObjectKey, cm *corev1.ConfigMap) error {
+					cm.Data = map[string]string{
+						config.ConfigFileName:  "name: foo\ncronExpression: invalid",
+						config.PodTemplateName: "kind: Pod",
+					}
+					return nil
+				})
+
+			_, err := r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg).Should(BeNil())
+			Ω(r.Config).Should(Equal(cfg))
+		})
+		It("should keep the current config if job names are not unique", func() {
+			mockLog.EXPECT().Error(gm.Any(), gm.Any())
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.ConfigMap{})).
+				Do(func(ctx context.Context, key client.ObjectKey, cm *corev1.ConfigMap) error {
+					cm.Data = map[string]string{
+						config.ConfigFileName:  "jobs:\n- name: a\n  cronExpression: '* * * * *'\n- name: a\n  cronExpression: '* * * * *'",
+						config.PodTemplateName: "kind: Pod",
+					}
+					return nil
+				})
+
+			_, err := r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.cfg).Should(BeNil())
+		})
+	})
+})
+
+type configTarget struct {
+	cfg *config.Config
+}
+
+func (t *configTarget) InjectConfig(cfg *config.Config) {
+	t.cfg = cfg
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"strconv"
+
 	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -20,6 +22,10 @@ const (
 	LabelOwner = "batch-job-controller.bakito.github.com/owner"
 	// LabelExecutionID execution id label
 	LabelExecutionID = "batch-job-controller.bakito.github.com/execution-id"
+	// LabelAttempt attempt label
+	LabelAttempt = "batch-job-controller.bakito.github.com/attempt"
+	// AnnotationTarget target name annotation
+	AnnotationTarget = "batch-job-controller.bakito.github.com/target"
 )
 
 // PodReconciler reconciler
@@ -27,6 +33,8 @@ type PodReconciler struct {
 	client.Client
 	Log   logr.Logger
 	Cache lifecycle.Cache
+	// Restored is closed once the cache is restored, pods of unknown executions are requeued until then
+	Restored <-chan struct{}
 }
 
 // SetupWithManager setup
@@ -56,14 +64,16 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return reconcile.Result{}, err
 	}
 
+	jobName := pod.GetLabels()[LabelOwner]
 	executionID := pod.GetLabels()[LabelExecutionID]
-	node := pod.Spec.NodeName
+	attempt := Attempt(pod)
+	node := Target(pod)
 
 	switch pod.Status.Phase {
 	case corev1.PodSucceeded:
-		err = r.Cache.PodTerminated(executionID, node, pod.Status.Phase)
+		err = r.Cache.PodTerminated(jobName, executionID, node, attempt, pod.Status.Phase)
 	case corev1.PodFailed:
-		err = r.Cache.PodTerminated(executionID, node, pod.Status.Phase)
+		err = r.Cache.PodTerminated(jobName, executionID, node, attempt, pod.Status.Phase)
 	}
 	if err != nil {
 
@@ -71,11 +81,43 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			podLog.Error(err, "unexpected error")
 			return reconcile.Result{}, err
 		}
+		if !r.restored() {
+			// the execution of the pod might not be restored yet
+			return reconcile.Result{Requeue: true}, nil
+		}
 	}
 
 	return reconcile.Result{}, nil
 }
 
+func (r *PodReconciler) restored() bool {
+	if r.Restored == nil {
+		return true
+	}
+	select {
+	case <-r.Restored:
+		return true
+	default:
+		return false
+	}
+}
+
+// Attempt get the attempt of the job pod, pods without attempt label are the first attempt
+func Attempt(pod metav1.Object) int {
+	if a, err := strconv.Atoi(pod.GetLabels()[LabelAttempt]); err == nil {
+		return a
+	}
+	return 1
+}
+
+// Target get the name of the target of the job pod, pods without target annotation are started for their node
+func Target(pod *corev1.Pod) string {
+	if t, ok := pod.GetAnnotations()[AnnotationTarget]; ok {
+		return t
+	}
+	return pod.Spec.NodeName
+}
+
 type podPredicate struct {
 }
 
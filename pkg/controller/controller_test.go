@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	mock_cache "github.com/bakito/batch-job-controller/pkg/mocks/cache"
 	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
 	mock_logr "github.com/bakito/batch-job-controller/pkg/mocks/logr"
@@ -99,7 +100,7 @@ var _ = Describe("Controller", func() {
 					}
 					return nil
 				})
-			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), corev1.PodSucceeded)
+			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), gm.Any(), 1, corev1.PodSucceeded)
 
 			result, err := r.Reconcile(ctrl.Request{})
 			Ω(err).ShouldNot(HaveOccurred())
@@ -116,13 +117,36 @@ var _ = Describe("Controller", func() {
 					}
 					return nil
 				})
-			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), corev1.PodFailed)
+			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), gm.Any(), 1, corev1.PodFailed)
 
 			result, err := r.Reconcile(ctrl.Request{})
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(result).ShouldNot(BeNil())
 			Ω(result.Requeue).Should(BeFalse())
 		})
+		It("should requeue pods of unknown executions until the cache is restored", func() {
+			restored := make(chan struct{})
+			r.Restored = restored
+			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog).Times(2)
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.Pod{})).
+				Do(func(ctx context.Context, key client.ObjectKey, pod *corev1.Pod) error {
+					pod.Status = corev1.PodStatus{
+						Phase: corev1.PodSucceeded,
+					}
+					return nil
+				}).Times(2)
+			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), gm.Any(), 1, corev1.PodSucceeded).
+				Return(&lifecycle.ExecutionIDNotFound{Err: fmt.Errorf("not found")}).Times(2)
+
+			result, err := r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Requeue).Should(BeTrue())
+
+			close(restored)
+			result, err = r.Reconcile(ctrl.Request{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Requeue).Should(BeFalse())
+		})
 		It("should return error on update cache error", func() {
 			mockLog.EXPECT().WithValues(gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Error(gm.Any(), gm.Any())
@@ -133,7 +157,7 @@ var _ = Describe("Controller", func() {
 					}
 					return nil
 				})
-			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), corev1.PodSucceeded).Return(fmt.Errorf("error"))
+			mockCache.EXPECT().PodTerminated(gm.Any(), gm.Any(), gm.Any(), 1, corev1.PodSucceeded).Return(fmt.Errorf("error"))
 
 			result, err := r.Reconcile(ctrl.Request{})
 			Ω(err).Should(HaveOccurred())
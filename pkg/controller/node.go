@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NodeReconciler removes the metrics of deleted nodes
+type NodeReconciler struct {
+	client.Client
+	Log   logr.Logger
+	Cache lifecycle.Cache
+}
+
+// SetupWithManager setup
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}).
+		WithEventFilter(&nodeDeletedPredicate{}).
+		Complete(r)
+}
+
+// Reconcile reconcile nodes
+func (r *NodeReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	nodeLog := r.Log.WithValues("node", req.Name)
+	node := &corev1.Node{}
+	err := r.Get(context.Background(), req.NamespacedName, node)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			r.Cache.NodeRemoved(req.Name)
+			return reconcile.Result{}, nil
+		}
+		nodeLog.Error(err, "unexpected error")
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+type nodeDeletedPredicate struct {
+}
+
+func (nodeDeletedPredicate) Create(event.CreateEvent) bool {
+	return false
+}
+
+func (nodeDeletedPredicate) Update(event.UpdateEvent) bool {
+	return false
+}
+
+func (nodeDeletedPredicate) Delete(event.DeleteEvent) bool {
+	return true
+}
+
+func (nodeDeletedPredicate) Generic(event.GenericEvent) bool {
+	return false
+}
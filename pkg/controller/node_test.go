@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+
+	mock_cache "github.com/bakito/batch-job-controller/pkg/mocks/cache"
+	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	mock_logr "github.com/bakito/batch-job-controller/pkg/mocks/logr"
+	gm "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Node", func() {
+	It("should only pass delete events", func() {
+		p := &nodeDeletedPredicate{}
+		n := &corev1.Node{}
+		Ω(p.Create(event.CreateEvent{Meta: n})).Should(BeFalse())
+		Ω(p.Update(event.UpdateEvent{MetaNew: n})).Should(BeFalse())
+		Ω(p.Delete(event.DeleteEvent{Meta: n})).Should(BeTrue())
+		Ω(p.Generic(event.GenericEvent{Meta: n})).Should(BeFalse())
+	})
+
+	Context("Reconcile", func() {
+		var (
+			r          *NodeReconciler
+			mockCtrl   *gm.Controller //gomock struct
+			mockCache  *mock_cache.MockCache
+			mockClient *mock_client.MockClient
+			mockLog    *mock_logr.MockLogger
+			req        ctrl.Request
+		)
+		BeforeEach(func() {
+			mockCtrl = gm.NewController(GinkgoT())
+			mockCache = mock_cache.NewMockCache(mockCtrl)
+			mockClient = mock_client.NewMockClient(mockCtrl)
+			mockLog = mock_logr.NewMockLogger(mockCtrl)
+			r = &NodeReconciler{Client: mockClient, Log: mockLog, Cache: mockCache}
+			req = ctrl.Request{NamespacedName: types.NamespacedName{Name: "node1"}}
+			mockLog.EXPECT().WithValues("node", "node1").Return(mockLog)
+		})
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+		It("should remove the metrics of a deleted node", func() {
+			mockClient.EXPECT().Get(gm.Any(), req.NamespacedName, gm.AssignableToTypeOf(&corev1.Node{})).
+				Return(k8serrors.NewNotFound(schema.GroupResource{Resource: "nodes"}, "node1"))
+			mockCache.EXPECT().NodeRemoved("node1")
+
+			result, err := r.Reconcile(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Requeue).Should(BeFalse())
+		})
+		It("should keep the metrics of an existing node", func() {
+			mockClient.EXPECT().Get(gm.Any(), req.NamespacedName, gm.AssignableToTypeOf(&corev1.Node{})).Return(nil)
+
+			_, err := r.Reconcile(req)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		It("should fail on an unexpected error", func() {
+			mockClient.EXPECT().Get(gm.Any(), req.NamespacedName, gm.AssignableToTypeOf(&corev1.Node{})).Return(fmt.Errorf("error"))
+			mockLog.EXPECT().Error(gm.Any(), "unexpected error")
+
+			_, err := r.Reconcile(req)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// RestoreCacheBefore restore the cache once the manager is elected as leader and start the runnable afterwards,
+// e.g. the scheduler, that has to know the running executions. The restored channel is closed once the cache is restored.
+func RestoreCacheBefore(cl client.Client, namespace string, cache lifecycle.Cache, restored chan<- struct{}, r manager.Runnable) manager.Runnable {
+	return manager.RunnableFunc(func(stop <-chan struct{}) error {
+		if err := RestoreCache(cl, namespace, cache); err != nil {
+			ctrl.Log.WithName("restore").Error(err, "unable to restore cache")
+		}
+		close(restored)
+		return r.Start(stop)
+	})
+}
+
+// RestoreCache rebuild the state of the cache from the existing job pods and the report directories
+// to allow running executions to survive a restart of the controller
+func RestoreCache(cl client.Client, namespace string, cache lifecycle.Cache) error {
+	restoreLog := ctrl.Log.WithName("restore")
+
+	podList := &corev1.PodList{}
+	err := cl.List(context.TODO(), podList, client.InNamespace(namespace), client.HasLabels{LabelOwner, LabelExecutionID})
+	if err != nil {
+		return err
+	}
+
+	pods := make(map[string][]lifecycle.PodState)
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		jobName := p.GetLabels()[LabelOwner]
+		ps := lifecycle.PodState{
+			ExecutionID: p.GetLabels()[LabelExecutionID],
+			Node:        Target(p),
+			Attempt:     Attempt(p),
+			Started:     p.CreationTimestamp.Time,
+			Phase:       p.Status.Phase,
+			Pod:         p,
+			Delete: func() error {
+				return client.IgnoreNotFound(cl.Delete(context.TODO(), p))
+			},
+		}
+		if p.Status.StartTime != nil {
+			ps.Started = p.Status.StartTime.Time
+		}
+		pods[jobName] = append(pods[jobName], ps)
+	}
+
+	cfg := cache.Config()
+	for _, jc := range cfg.JobConfigs() {
+		if err := cache.Restore(jc.Name, pods[jc.Name]); err != nil {
+			restoreLog.WithValues("job", jc.Name).Error(err, "could not restore job")
+		}
+		delete(pods, jc.Name)
+	}
+	for jobName := range pods {
+		restoreLog.WithValues("job", jobName).Info("ignoring pods of unknown job")
+	}
+	return nil
+}
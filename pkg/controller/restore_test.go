@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	mock_cache "github.com/bakito/batch-job-controller/pkg/mocks/cache"
+	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	gm "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var _ = Describe("Restore", func() {
+	var (
+		mockCtrl   *gm.Controller //gomock struct
+		mockCache  *mock_cache.MockCache
+		mockClient *mock_client.MockClient
+		started    time.Time
+	)
+	BeforeEach(func() {
+		mockCtrl = gm.NewController(GinkgoT())
+		mockCache = mock_cache.NewMockCache(mockCtrl)
+		mockClient = mock_client.NewMockClient(mockCtrl)
+		started = time.Now().Add(-time.Minute).Truncate(time.Second)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+	It("should restore the cache from the job pods", func() {
+		mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.PodList{}), client.InNamespace("ns"), client.HasLabels{LabelOwner, LabelExecutionID}).
+			Do(func(ctx context.Context, list *corev1.PodList, opts ...client.ListOption) error {
+				list.Items = []corev1.Pod{
+					pod("a", "id1", "node1", &started),
+					pod("a", "id1", "node2", nil),
+					pod("unknown", "id1", "node1", nil),
+				}
+				list.Items[1].Status.Phase = corev1.PodSucceeded
+				return nil
+			})
+		mockCache.EXPECT().Config().Return(config.Config{Jobs: []config.Config{{Name: "a"}, {Name: "b"}}})
+		mockCache.EXPECT().Restore("a", gm.Any()).Do(func(jobName string, pods []lifecycle.PodState) {
+			Ω(pods).Should(HaveLen(2))
+			Ω(pods[0].ExecutionID).Should(Equal("id1"))
+			Ω(pods[0].Node).Should(Equal("node1"))
+			Ω(pods[0].Started).Should(Equal(started))
+			Ω(pods[1].Node).Should(Equal("node2"))
+			Ω(pods[1].Phase).Should(Equal(corev1.PodSucceeded))
+
+			// restored pods are deleted when they exceed the job timeout
+			mockClient.EXPECT().Delete(gm.Any(), pods[1].Pod)
+			Ω(pods[1].Delete()).ShouldNot(HaveOccurred())
+		})
+		mockCache.EXPECT().Restore("b", gm.Len(0))
+
+		Ω(RestoreCache(mockClient, "ns", mockCache)).ShouldNot(HaveOccurred())
+	})
+	It("should restore the target of pods without node", func() {
+		mockClient.EXPECT().List(gm.Any(), gm.Any(), gm.Any(), gm.Any()).
+			Do(func(ctx context.Context, list *corev1.PodList, opts ...client.ListOption) error {
+				p := pod("a", "id1", "", nil)
+				p.Annotations = map[string]string{AnnotationTarget: "namespace-a"}
+				list.Items = []corev1.Pod{p}
+				return nil
+			})
+		mockCache.EXPECT().Config().Return(config.Config{Name: "a"})
+		mockCache.EXPECT().Restore("a", gm.Any()).Do(func(jobName string, pods []lifecycle.PodState) {
+			Ω(pods).Should(HaveLen(1))
+			Ω(pods[0].Node).Should(Equal("namespace-a"))
+		})
+
+		Ω(RestoreCache(mockClient, "ns", mockCache)).ShouldNot(HaveOccurred())
+	})
+	It("should return the error of the reader", func() {
+		mockClient.EXPECT().List(gm.Any(), gm.Any(), gm.Any(), gm.Any()).Return(fmt.Errorf("error"))
+
+		Ω(RestoreCache(mockClient, "ns", mockCache)).Should(HaveOccurred())
+	})
+	It("should restore the cache before the runnable is started", func() {
+		restored := false
+		mockClient.EXPECT().List(gm.Any(), gm.Any(), gm.Any(), gm.Any())
+		mockCache.EXPECT().Config().Return(config.Config{Name: "a"})
+		mockCache.EXPECT().Restore("a", gm.Len(0)).Do(func(string, []lifecycle.PodState) {
+			restored = true
+		})
+
+		done := make(chan struct{})
+		r := RestoreCacheBefore(mockClient, "ns", mockCache, done, manager.RunnableFunc(func(<-chan struct{}) error {
+			Ω(restored).Should(BeTrue())
+			Ω(done).Should(BeClosed())
+			return nil
+		}))
+		Ω(r.Start(make(chan struct{}))).ShouldNot(HaveOccurred())
+		Ω(restored).Should(BeTrue())
+	})
+})
+
+func pod(jobName, executionID, node string, started *time.Time) corev1.Pod {
+	p := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				LabelOwner:       jobName,
+				LabelExecutionID: executionID,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+		},
+	}
+	if started != nil {
+		p.Status.StartTime = &metav1.Time{Time: *started}
+	}
+	return p
+}
@@ -2,17 +2,26 @@ package cron
 
 import (
 	"context"
-	ctrl "sigs.k8s.io/controller-runtime"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/controller"
 	"github.com/bakito/batch-job-controller/pkg/job"
 	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	"github.com/go-logr/logr"
 	"github.com/robfig/cron/v3"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -20,109 +29,498 @@ var (
 	log = ctrl.Log.WithName("cron")
 )
 
-//Job prepare the static file server
-func Job(namespace string, cfg *config.Config, client client.Client, cache lifecycle.Cache, owner runtime.Object, extender ...job.CustomPodEnv) (*cron.Cron, error) {
+// Scheduler the scheduler of the job executions
+type Scheduler interface {
+	lifecycle.Scheduler
+	// Start the scheduler, missed executions are caught up on start
+	Start(stop <-chan struct{}) error
+	// InjectConfig apply a changed config
+	InjectConfig(cfg *config.Config)
+}
 
-	var cj = &cronJob{
+//Job prepare the cron scheduler with an entry for each job
+func Job(namespace string, cfg *config.Config, client client.Client, cache lifecycle.Cache, owner runtime.Object, filters []TargetFilter, mutators []job.CustomPodMutator, extender ...job.CustomPodEnv) (Scheduler, error) {
+
+	s := &scheduler{
 		namespace: namespace,
 		cache:     cache,
-		cfg:       cfg,
 		client:    client,
+		filters:   filters,
+		mutators:  mutators,
 		extender:  extender,
 		owner:     owner,
+		cron:      cron.New(),
+		jobs:      make(map[string]*cronJob),
 	}
-	log.WithValues("expression", cfg.CronExpression).Info("starting cron")
 
-	c := cron.New()
-	_, _ = c.AddFunc(cfg.CronExpression, cj.startPods)
+	for _, jc := range cfg.JobConfigs() {
+		s.jobs[jc.Name] = s.newCronJob(jc)
+	}
 
-	if cfg.RunOnStartup {
-		go func() {
-			time.Sleep(time.Second * 10)
-			log.Info("starting cron on startup")
-			cj.startPods()
-		}()
+	return s, nil
+}
+
+type scheduler struct {
+	namespace string
+	client    client.Client
+	cron      *cron.Cron
+	cache     lifecycle.Cache
+	recorder  record.EventRecorder
+	filters   []TargetFilter
+	mutators  []job.CustomPodMutator
+	extender  []job.CustomPodEnv
+	owner     runtime.Object
+	jobs      map[string]*cronJob
+	started   bool
+	lock      sync.Mutex
+}
+
+// Start the cron scheduler until the stop channel is closed.
+// As a runnable of the manager it is started once the controller is the leader
+func (s *scheduler) Start(stop <-chan struct{}) error {
+	s.lock.Lock()
+	for _, cj := range s.jobs {
+		go cj.startup()
+	}
+	s.started = true
+	s.lock.Unlock()
+
+	s.cron.Start()
+	<-stop
+	// wait for running cron functions
+	<-s.cron.Stop().Done()
+	return nil
+}
+
+// InjectConfig apply a changed config, add new jobs, remove deleted jobs and reschedule if the cron expression has changed
+func (s *scheduler) InjectConfig(cfg *config.Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	jobs := make(map[string]*cronJob)
+	for _, jc := range cfg.JobConfigs() {
+		if cj, ok := s.jobs[jc.Name]; ok {
+			cj.injectConfig(jc)
+			jobs[jc.Name] = cj
+		} else {
+			cj := s.newCronJob(jc)
+			jobs[jc.Name] = cj
+			if s.started {
+				go cj.startup()
+			}
+		}
+	}
+	for name, cj := range s.jobs {
+		if _, ok := jobs[name]; !ok {
+			s.cron.Remove(cj.entryID)
+			log.WithValues("job", name).Info("removed cron")
+		}
+	}
+	s.jobs = jobs
+}
+
+// InjectEventRecorder inject the event recorder used to report overlapping executions
+func (s *scheduler) InjectEventRecorder(er record.EventRecorder) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.recorder = er
+	for _, cj := range s.jobs {
+		cj.recorder = er
+	}
+}
+
+// Trigger start a new execution of the job immediately and return its id
+func (s *scheduler) Trigger(jobName string, opts lifecycle.TriggerOptions) (string, error) {
+	cj, err := s.job(jobName)
+	if err != nil {
+		return "", err
+	}
+	return cj.trigger(opts)
+}
+
+// Cancel cancel a running execution and delete its remaining pods
+func (s *scheduler) Cancel(jobName string, executionID string) error {
+	cj, err := s.job(jobName)
+	if err != nil {
+		return err
+	}
+	return cj.cancel(executionID)
+}
+
+func (s *scheduler) job(name string) (*cronJob, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cj, ok := s.jobs[name]
+	if !ok {
+		return nil, &lifecycle.ExecutionIDNotFound{Err: fmt.Errorf("job '%s' not found", name)}
 	}
+	return cj, nil
+}
 
-	return c, nil
+func (s *scheduler) newCronJob(cfg *config.Config) *cronJob {
+	cj := &cronJob{
+		namespace: s.namespace,
+		cache:     s.cache,
+		recorder:  s.recorder,
+		cfg:       cfg,
+		client:    s.client,
+		filters:   s.filters,
+		mutators:  s.mutators,
+		extender:  s.extender,
+		owner:     s.owner,
+		job:       s.cron,
+	}
+	log.WithValues("job", cfg.Name, "expression", cfg.Schedule()).Info("starting cron")
+	cj.entryID, _ = s.cron.AddFunc(cfg.Schedule(), cj.scheduled)
+	return cj
 }
 
 type cronJob struct {
 	namespace string
 	client    client.Client
 	job       *cron.Cron
+	entryID   cron.EntryID
 	cache     lifecycle.Cache
-	running   bool
+	recorder  record.EventRecorder
+	startLock sync.Mutex
 	cfg       *config.Config
+	cfgLock   sync.RWMutex
+	filters   []TargetFilter
+	mutators  []job.CustomPodMutator
 	extender  []job.CustomPodEnv
 	owner     runtime.Object
 }
 
-func (j *cronJob) deleteAll(obj runtime.Object) error {
+// injectConfig apply a changed config and reschedule if the cron expression has changed
+func (j *cronJob) injectConfig(cfg *config.Config) {
+	j.cfgLock.Lock()
+	defer j.cfgLock.Unlock()
+
+	if j.cfg.Schedule() != cfg.Schedule() {
+		id, err := j.job.AddFunc(cfg.Schedule(), j.scheduled)
+		if err != nil {
+			log.WithValues("job", cfg.Name, "expression", cfg.Schedule()).Error(err, "could not reschedule cron, keeping current schedule")
+		} else {
+			j.job.Remove(j.entryID)
+			j.entryID = id
+			log.WithValues("job", cfg.Name, "expression", cfg.Schedule(), "next", j.next(cfg.Name)).Info("rescheduled cron")
+		}
+	}
+	j.cfg = cfg
+}
+
+// next get the time of the next scheduled execution and expose it as metric
+func (j *cronJob) next(jobName string) time.Time {
+	e := j.job.Entry(j.entryID)
+	if e.Schedule == nil {
+		return time.Time{}
+	}
+	next := e.Schedule.Next(time.Now())
+	j.cache.NextSchedule(jobName, next)
+	return next
+}
+
+func (j *cronJob) config() *config.Config {
+	j.cfgLock.RLock()
+	defer j.cfgLock.RUnlock()
+	return j.cfg
+}
+
+// deleteAll delete all objects of the job except the ones of the excluded executions
+func (j *cronJob) deleteAll(obj runtime.Object, excludedExecutions ...string) error {
+	var matching client.DeleteAllOfOption = job.MatchingLabels(j.config().Name)
+	if len(excludedExecutions) > 0 {
+		selector := labels.SelectorFromSet(labels.Set(job.MatchingLabels(j.config().Name)))
+		req, err := labels.NewRequirement(controller.LabelExecutionID, selection.NotIn, excludedExecutions)
+		if err != nil {
+			return err
+		}
+		matching = client.MatchingLabelsSelector{Selector: selector.Add(*req)}
+	}
 	return j.client.DeleteAllOf(
 		context.TODO(),
 		obj,
 		client.InNamespace(j.namespace),
-		job.MatchingLabels(j.cfg.Name),
+		matching,
 		client.PropagationPolicy(metav1.DeletePropagationBackground),
 	) // set propagation policy to also delete assigned pods
 }
 
+// scheduled start the pods of a scheduled execution and persist the schedule time
+func (j *cronJob) scheduled() {
+	j.recordSchedule(time.Now())
+	j.next(j.config().Name)
+	j.startPods()
+}
+
+// startup catch up the last missed schedule if within the starting deadline, or start the pods if the job runs on startup
+func (j *cronJob) startup() {
+	cfg := j.config()
+	jobLog := log.WithValues("job", cfg.Name)
+	jobLog.Info("scheduled next execution", "expression", cfg.Schedule(), "next", j.next(cfg.Name))
+
+	now := time.Now()
+	last, err := j.cache.LastScheduled(cfg.Name)
+	if err != nil {
+		jobLog.Error(err, "unable to read last schedule time")
+	} else if last.IsZero() {
+		// first start of the job, nothing could have been missed
+		j.recordSchedule(now)
+	} else {
+		missed, count, err := missedSchedule(cfg.Schedule(), last, now)
+		if err != nil {
+			jobLog.Error(err, "unable to evaluate missed schedules")
+		} else if count > 0 {
+			missedLog := jobLog.WithValues("missed", count, "last", missed, "deadline", cfg.StartingDeadlineDuration())
+			if deadline := cfg.StartingDeadlineDuration(); deadline > 0 && now.Sub(missed) <= deadline {
+				missedLog.Info("starting missed execution")
+				j.recordSchedule(missed)
+				j.startPods()
+				return
+			}
+			missedLog.Info("missed executions are not started, starting deadline exceeded")
+		}
+	}
+
+	if cfg.RunOnStartup {
+		jobLog.Info("starting cron on startup")
+		j.startPods()
+	}
+}
+
+func (j *cronJob) recordSchedule(t time.Time) {
+	if err := j.cache.Scheduled(j.config().Name, t); err != nil {
+		log.WithValues("job", j.config().Name).Error(err, "unable to persist schedule time")
+	}
+}
+
+// missedSchedule get the latest schedule after last that is due at now and the number of missed schedules
+func missedSchedule(expression string, last time.Time, now time.Time) (time.Time, int, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	var missed time.Time
+	count := 0
+	for t := schedule.Next(last); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		missed = t
+		count++
+	}
+	return missed, count, nil
+}
+
 func (j *cronJob) startPods() {
-	if j.running {
-		log.Info("last cronjob still running")
+	e, err := j.prepare(lifecycle.TriggerOptions{})
+	if err != nil {
 		return
 	}
-	j.running = true
-	defer func() {
-		j.running = false
-	}()
+	e.addPods()
+}
+
+// trigger start a new execution, the pods are added asynchronously
+func (j *cronJob) trigger(opts lifecycle.TriggerOptions) (string, error) {
+	e, err := j.prepare(opts)
+	if err != nil {
+		return "", err
+	}
+	go e.addPods()
+	return e.id, nil
+}
 
-	executionID := j.cache.NewExecution()
+// startingExecution an execution with its targets to add the pods for
+type startingExecution struct {
+	*cronJob
+	id        string
+	cfg       *config.Config
+	targets   []job.Target
+	serviceIP string
+	log       logr.Logger
+}
+
+// prepare create a new execution and evaluate the targets to run the pods for
+func (j *cronJob) prepare(opts lifecycle.TriggerOptions) (*startingExecution, error) {
+	cfg := j.config()
+
+	targets, err := j.targets(cfg, opts)
+	if err != nil {
+		log.WithValues("job", cfg.Name, "kind", cfg.TargetKind()).Error(err, "unable to evaluate targets")
+		return nil, err
+	}
+
+	// decide and start new executions one at a time
+	j.startLock.Lock()
+	defer j.startLock.Unlock()
+
+	active, err := j.cache.ActiveExecutions(cfg.Name)
+	if err != nil {
+		log.WithValues("job", cfg.Name).Error(err, "unable to evaluate active executions")
+		return nil, err
+	}
+	if len(active) > 0 {
+		active, err = j.applyConcurrencyPolicy(cfg, active)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	executionID, err := j.cache.NewExecution(cfg.Name)
+	if err != nil {
+		log.WithValues("job", cfg.Name).Error(err, "unable to start execution")
+		return nil, err
+	}
 
-	jobLog := log.WithValues("id", executionID)
+	jobLog := log.WithValues("job", cfg.Name, "id", executionID)
 
-	err := j.deleteAll(&corev1.Pod{})
+	// keep the pods of executions running side by side
+	err = j.deleteAll(&corev1.Pod{}, active...)
 	if err != nil {
 		jobLog.Error(err, "unable to delete old pods")
-		return
+		return nil, err
 	}
 
 	// get service
 	svc := &corev1.Service{}
-	err = j.client.Get(context.TODO(), client.ObjectKey{Namespace: j.cfg.Namespace, Name: j.cfg.CallbackServiceName}, svc)
+	err = j.client.Get(context.TODO(), client.ObjectKey{Namespace: cfg.Namespace, Name: cfg.CallbackServiceName}, svc)
 	if err != nil {
-		jobLog.Error(err, "error getting service %q", j.cfg.CallbackServiceName)
+		jobLog.Error(err, "error getting service %q", cfg.CallbackServiceName)
 	}
 
-	// Fetch the ReplicaSet from the cache
-	nodeList := &corev1.NodeList{}
-	err = j.client.List(context.TODO(), nodeList, client.MatchingLabels(j.cfg.JobNodeSelector))
+	return &startingExecution{
+		cronJob:   j,
+		id:        executionID,
+		cfg:       cfg,
+		targets:   targets,
+		serviceIP: svc.Spec.ClusterIP,
+		log:       jobLog,
+	}, nil
+}
+
+// targets get the targets of the provider of the job after applying the target filters,
+// restricted to the names of the trigger and without the excluded ones
+func (j *cronJob) targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error) {
+	provider, err := j.targetProvider(cfg)
 	if err != nil {
-		jobLog.Error(err, "error listing nodes")
-		return
+		return nil, err
+	}
+	all, err := provider.Targets(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range j.filters {
+		if all, err = f.FilterTargets(cfg, all); err != nil {
+			return nil, err
+		}
 	}
 
-	jobLog.Info("executing job")
-	for _, n := range nodeList.Items {
-		if isUsable(n, j.cfg.RunOnUnscheduledNodes) {
-			pod, err := job.New(j.cfg, n.ObjectMeta.Name, executionID, svc.Spec.ClusterIP, j.owner, j.extender...)
-			if err != nil {
-				jobLog.Error(err, "error creating pod from template")
-				return
+	names := make(map[string]bool)
+	for _, n := range opts.Nodes {
+		names[n] = true
+	}
+	var targets []job.Target
+	for _, t := range all {
+		if len(names) > 0 && !names[t.Name] {
+			continue
+		}
+		if cfg.IsExcluded(t.Name) {
+			log.WithValues("job", cfg.Name).V(4).Info("skipping excluded target", "target", t.Name)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// addPods add the pods of the execution to the cache
+func (e *startingExecution) addPods() {
+	e.log.Info("executing job", "targets", len(e.targets))
+	for _, t := range e.targets {
+		t := t
+		// the pod is rendered for each attempt
+		build := func(attempt int) (*corev1.Pod, error) {
+			return job.New(e.cfg, t, e.id, attempt, e.serviceIP, e.owner, e.mutators, e.extender...)
+		}
+		// pods that could not be built are added with the error to be reported as failed creation
+		pod, err := build(1)
+		if err != nil {
+			e.log.Error(err, "error creating pod", "target", t.Name)
+		} else if e.cfg.SkipUntoleratedNodes {
+			if taint, ok := untoleratedTaint(pod, t.Taints); ok {
+				e.log.Info("skipping target with untolerated taint", "target", t.Name, "taint", taint.ToString())
+				continue
 			}
+		}
 
-			_ = j.cache.AddPod(&podJob{
-				id:       executionID,
-				nodeName: n.ObjectMeta.Name,
-				log:      jobLog,
-				client:   j.client,
-				pod:      pod,
-			})
+		err = e.cache.AddPod(&podJob{
+			id:       e.id,
+			jobName:  e.cfg.Name,
+			nodeName: t.Name,
+			attempt:  1,
+			log:      e.log,
+			client:   e.client,
+			pod:      pod,
+			err:      err,
+			build:    build,
+		})
+		if err != nil {
+			e.log.Error(err, "stop adding pods")
+			break
 		}
 	}
 
-	_ = j.cache.AllAdded(executionID)
+	_ = e.cache.AllAdded(e.cfg.Name, e.id)
+}
+
+// applyConcurrencyPolicy decide if a new execution can be started while other executions are still active
+// returns the executions that keep running
+func (j *cronJob) applyConcurrencyPolicy(cfg *config.Config, active []string) ([]string, error) {
+	policyLog := log.WithValues("job", cfg.Name, "active", active, "policy", cfg.Concurrency())
+	switch cfg.Concurrency() {
+	case config.ForbidConcurrent:
+		j.cache.ExecutionOverlapped(cfg.Name, lifecycle.OverlapSkipped)
+		j.event(corev1.EventTypeWarning, "ExecutionSkipped", "skipped execution of job %s, executions %v are still active", cfg.Name, active)
+		policyLog.Info("skipping execution, last execution is still active")
+		return nil, &lifecycle.ExecutionRunning{Err: fmt.Errorf("executions %v of job %q are still active", active, cfg.Name)}
+	case config.ReplaceConcurrent:
+		for _, id := range active {
+			if err := j.cancel(id); err != nil {
+				policyLog.Error(err, "unable to cancel active execution", "id", id)
+				return nil, err
+			}
+		}
+		j.cache.ExecutionOverlapped(cfg.Name, lifecycle.OverlapReplaced)
+		j.event(corev1.EventTypeNormal, "ExecutionReplaced", "cancelled active executions %v of job %s to start a new execution", active, cfg.Name)
+		policyLog.Info("replacing active executions")
+		return nil, nil
+	default:
+		j.cache.ExecutionOverlapped(cfg.Name, lifecycle.OverlapAllowed)
+		policyLog.Info("starting execution concurrently")
+		return active, nil
+	}
+}
+
+func (j *cronJob) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if j.recorder != nil && j.owner != nil {
+		j.recorder.Eventf(j.owner, eventType, reason, messageFmt, args...)
+	}
+}
+
+// cancel cancel the execution and delete its pods that are not terminated
+func (j *cronJob) cancel(executionID string) error {
+	cfg := j.config()
+	if err := j.cache.Cancel(cfg.Name, executionID); err != nil {
+		return err
+	}
+	return j.client.DeleteAllOf(
+		context.TODO(),
+		&corev1.Pod{},
+		client.InNamespace(j.namespace),
+		client.MatchingLabels{controller.LabelOwner: cfg.Name, controller.LabelExecutionID: executionID},
+		client.MatchingFieldsSelector{Selector: fields.AndSelectors(
+			fields.OneTermNotEqualSelector("status.phase", string(corev1.PodSucceeded)),
+			fields.OneTermNotEqualSelector("status.phase", string(corev1.PodFailed)),
+		)},
+		client.PropagationPolicy(metav1.DeletePropagationBackground),
+	)
 }
 
 func isUsable(node corev1.Node, runOnUnscheduledNodes bool) bool {
@@ -137,26 +535,94 @@ func isUsable(node corev1.Node, runOnUnscheduledNodes bool) bool {
 	return false
 }
 
+// untoleratedTaint get the first NoSchedule or NoExecute taint that is not tolerated by the pod
+func untoleratedTaint(pod *corev1.Pod, taints []corev1.Taint) (corev1.Taint, bool) {
+	for _, taint := range taints {
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for i := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[i].ToleratesTaint(&taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint, true
+		}
+	}
+	return corev1.Taint{}, false
+}
+
 type podJob struct {
 	id       string
+	jobName  string
 	nodeName string
+	attempt  int
 	log      logr.Logger
 	pod      *corev1.Pod
 	client   client.Client
+	err      error
+	build    func(attempt int) (*corev1.Pod, error)
 }
 
 func (j *podJob) ID() string {
 	return j.id
 }
 
+func (j *podJob) JobName() string {
+	return j.jobName
+}
+
 func (j *podJob) Node() string {
 	return j.nodeName
 }
 
-func (j *podJob) Process() {
-	log.Info("create pod", "node", j.nodeName)
-	err := j.client.Create(context.TODO(), j.pod)
-	if err != nil {
-		log.Error(err, "unable to create pod", "node", j.nodeName)
+func (j *podJob) Attempt() int {
+	return j.attempt
+}
+
+func (j *podJob) Process() error {
+	if j.err != nil {
+		return j.err
+	}
+	log.Info("create pod", "job", j.jobName, "node", j.nodeName, "attempt", j.attempt)
+	if j.attempt == 1 {
+		return j.client.Create(context.TODO(), j.pod)
 	}
+	// the pod of the previous attempt has the same name, wait until it is deleted
+	return wait.PollImmediate(time.Second, time.Minute, func() (bool, error) {
+		err := j.client.Create(context.TODO(), j.pod)
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+func (j *podJob) Retry() lifecycle.Job {
+	next := &podJob{
+		id:       j.id,
+		jobName:  j.jobName,
+		nodeName: j.nodeName,
+		attempt:  j.attempt + 1,
+		log:      j.log,
+		client:   j.client,
+		build:    j.build,
+	}
+	next.pod, next.err = j.build(next.attempt)
+	return next
+}
+
+func (j *podJob) Delete() error {
+	if j.pod == nil {
+		return nil
+	}
+	log.Info("delete pod", "job", j.jobName, "node", j.nodeName)
+	return client.IgnoreNotFound(j.client.Delete(context.TODO(), j.pod, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
+
+func (j *podJob) Pod() *corev1.Pod {
+	return j.pod
 }
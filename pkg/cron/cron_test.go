@@ -2,17 +2,32 @@ package cron
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/controller"
 	"github.com/bakito/batch-job-controller/pkg/job"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	mock_cache "github.com/bakito/batch-job-controller/pkg/mocks/cache"
 	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	"github.com/ghodss/yaml"
 	gm "github.com/golang/mock/gomock"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var _ = Describe("Cron", func() {
@@ -46,6 +61,53 @@ var _ = Describe("Cron", func() {
 			err := cj.deleteAll(&corev1.Pod{})
 			Ω(err).ShouldNot(HaveOccurred())
 		})
+		It("should keep the pods of excluded executions", func() {
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.AssignableToTypeOf(&corev1.Pod{}), client.InNamespace(namespace), gm.Any(), client.PropagationPolicy(metav1.DeletePropagationBackground)).
+				Do(func(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+					sel := opts[1].(client.MatchingLabelsSelector)
+					Ω(sel.String()).Should(ContainSubstring(controller.LabelExecutionID + " notin (a,b)"))
+					Ω(sel.String()).Should(ContainSubstring(controller.LabelOwner + "=" + configName))
+					return nil
+				})
+
+			err := cj.deleteAll(&corev1.Pod{}, "a", "b")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Context("InjectConfig", func() {
+		BeforeEach(func() {
+			cj.cfg.CronExpression = "* * * * *"
+			cj.job = cron.New()
+			cj.entryID, _ = cj.job.AddFunc(cj.cfg.CronExpression, func() {})
+		})
+		It("should reschedule if the cron expression changed", func() {
+			mockCache.EXPECT().NextSchedule(configName, gm.Any())
+			oldID := cj.entryID
+			cj.injectConfig(&config.Config{Name: configName, CronExpression: "0 * * * *"})
+			Ω(cj.entryID).ShouldNot(Equal(oldID))
+			Ω(cj.job.Entries()).Should(HaveLen(1))
+			Ω(cj.config().CronExpression).Should(Equal("0 * * * *"))
+		})
+		It("should keep the schedule if the cron expression is unchanged", func() {
+			oldID := cj.entryID
+			cj.injectConfig(&config.Config{Name: configName, CronExpression: "* * * * *", PodPoolSize: 3})
+			Ω(cj.entryID).Should(Equal(oldID))
+			Ω(cj.config().PodPoolSize).Should(Equal(3))
+		})
+		It("should reschedule if the time zone changed", func() {
+			cj.cfg.CronExpression = "0 3 * * *"
+			cj.entryID, _ = cj.job.AddFunc(cj.cfg.Schedule(), func() {})
+			loc, err := time.LoadLocation("Asia/Tokyo")
+			Ω(err).ShouldNot(HaveOccurred())
+			mockCache.EXPECT().NextSchedule(configName, gm.Any()).Do(func(_ string, next time.Time) {
+				Ω(next.In(loc).Hour()).Should(Equal(3))
+			})
+
+			oldID := cj.entryID
+			cj.injectConfig(&config.Config{Name: configName, CronExpression: "0 3 * * *", CronTimeZone: "Asia/Tokyo"})
+			Ω(cj.entryID).ShouldNot(Equal(oldID))
+		})
 	})
 
 	Context("startPods", func() {
@@ -56,13 +118,15 @@ var _ = Describe("Cron", func() {
 			nodeSelector = map[string]string{"foo": "bar"}
 			cj.cfg.JobNodeSelector = nodeSelector
 			cj.cfg.JobPodTemplate = "kind: Pod"
-			mockCache.EXPECT().NewExecution()
-			mockCache.EXPECT().AllAdded(gm.Any())
+			mockCache.EXPECT().ActiveExecutions(configName)
+			mockCache.EXPECT().NewExecution(configName).Return("id", nil)
+			mockCache.EXPECT().AllAdded(configName, "id")
 			mockCache.EXPECT().AddPod(gm.Any())
 			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
 			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.Service{}))
-			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), client.MatchingLabels(nodeSelector)).
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any()).
 				Do(func(ctx context.Context, list *corev1.NodeList, opts ...client.ListOption) error {
+					Ω(opts[0].(client.MatchingLabelsSelector).String()).Should(Equal("foo=bar"))
 					list.Items = []corev1.Node{
 						{
 							Spec: corev1.NodeSpec{
@@ -85,4 +149,431 @@ var _ = Describe("Cron", func() {
 			cj.startPods()
 		})
 	})
+
+	Context("trigger", func() {
+		BeforeEach(func() {
+			cj.cfg.JobPodTemplate = "kind: Pod"
+			cj.cfg.JobNodeSelector = map[string]string{"env": "prod"}
+		})
+		It("should start the pods on the selected nodes", func() {
+			added := make(chan string, 2)
+			done := make(chan bool)
+			mockCache.EXPECT().ActiveExecutions(configName)
+			mockCache.EXPECT().NewExecution(configName).Return("id", nil)
+			mockCache.EXPECT().AddPod(gm.Any()).Do(func(j lifecycle.Job) {
+				added <- j.Node()
+			})
+			mockCache.EXPECT().AllAdded(configName, "id").Do(func(string, string) {
+				close(done)
+			})
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.Service{}))
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any()).
+				Do(func(ctx context.Context, list *corev1.NodeList, opts ...client.ListOption) error {
+					// the nodes must match the job and the trigger selector
+					Ω(opts[0].(client.MatchingLabelsSelector).String()).Should(Equal("env=prod,foo=bar"))
+					list.Items = []corev1.Node{readyNode("a"), readyNode("b")}
+					return nil
+				})
+
+			id, err := cj.trigger(lifecycle.TriggerOptions{Nodes: []string{"b"}, NodeSelector: "foo=bar"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(id).Should(Equal("id"))
+
+			Eventually(done).Should(BeClosed())
+			Ω(added).Should(Receive(Equal("b")))
+			Ω(added).ShouldNot(Receive())
+		})
+		It("should fail with an invalid node selector", func() {
+			_, err := cj.trigger(lifecycle.TriggerOptions{NodeSelector: "foo in (bar"})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("pod creation error", func() {
+		BeforeEach(func() {
+			cj.cfg.JobPodTemplate = "kind: Pod"
+			cj.mutators = []job.CustomPodMutator{&failingMutator{}}
+		})
+		It("should add the pods that could not be built as failing jobs", func() {
+			var added []lifecycle.Job
+			mockCache.EXPECT().ActiveExecutions(configName)
+			mockCache.EXPECT().NewExecution(configName).Return("id", nil)
+			mockCache.EXPECT().AddPod(gm.Any()).Do(func(j lifecycle.Job) {
+				added = append(added, j)
+			}).Times(2)
+			mockCache.EXPECT().AllAdded(configName, "id")
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.Service{}))
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any()).
+				Do(func(ctx context.Context, list *corev1.NodeList, opts ...client.ListOption) error {
+					list.Items = []corev1.Node{readyNode("a"), readyNode("b")}
+					return nil
+				})
+
+			cj.startPods()
+			Ω(added).Should(HaveLen(2))
+			Ω(added[0].Pod()).Should(BeNil())
+			Ω(added[0].Process()).Should(MatchError(ContainSubstring("mutation failed")))
+			Ω(added[0].Delete()).ShouldNot(HaveOccurred())
+			next := added[0].Retry()
+			Ω(next.Attempt()).Should(Equal(2))
+			Ω(next.Process()).Should(HaveOccurred())
+		})
+	})
+
+	Context("podJob", func() {
+		It("should render the pod of the next attempt", func() {
+			cfg := &config.Config{Name: configName, JobPodTemplate: "kind: Pod\nmetadata:\n  labels:\n    rendered: '{{ .Attempt }}'"}
+			build := func(attempt int) (*corev1.Pod, error) {
+				return job.New(cfg, job.Target{Name: "a"}, "id", attempt, "", nil, nil)
+			}
+			pod, err := build(1)
+			Ω(err).ShouldNot(HaveOccurred())
+			pj := &podJob{id: "id", jobName: configName, nodeName: "a", attempt: 1, pod: pod, build: build}
+
+			next := pj.Retry()
+			Ω(next.Attempt()).Should(Equal(2))
+			Ω(next.Pod().Labels["rendered"]).Should(Equal("2"))
+			Ω(next.Pod().Labels[controller.LabelAttempt]).Should(Equal("2"))
+			Ω(pod.Labels["rendered"]).Should(Equal("1"))
+		})
+	})
+
+	Context("pod deletion", func() {
+		var (
+			cfg    *config.Config
+			repDir string
+			pod    *corev1.Pod
+		)
+		BeforeEach(func() {
+			var err error
+			repDir, err = ioutil.TempDir("", "cron")
+			Ω(err).ShouldNot(HaveOccurred())
+			// each test registers the metrics of its cache
+			metrics.Registry = prometheus.NewRegistry()
+			cfg = &config.Config{
+				Name:            configName,
+				ReportDirectory: repDir,
+				PodPoolSize:     1,
+				ReportHistory:   1,
+				Metrics:         config.Metrics{Prefix: "cron"},
+			}
+			pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace}}
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(repDir)
+		})
+		It("should delete a timed out pod with the verbs granted by the role", func() {
+			cfg.JobTimeout = metav1.Duration{Duration: 10 * time.Millisecond}
+			cache, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			deleted := make(chan bool, 1)
+			gm.InOrder(
+				mockClient.EXPECT().Create(gm.Any(), pod),
+				mockClient.EXPECT().Delete(gm.Any(), pod, client.PropagationPolicy(metav1.DeletePropagationBackground)).
+					Do(func(context.Context, runtime.Object, ...client.DeleteOption) {
+						deleted <- true
+					}),
+			)
+
+			id, err := cache.NewExecution(configName)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cache.AddPod(&podJob{id: id, jobName: configName, nodeName: "a", attempt: 1, pod: pod, client: mockClient})).
+				ShouldNot(HaveOccurred())
+			Ω(cache.AllAdded(configName, id)).ShouldNot(HaveOccurred())
+
+			Eventually(deleted, 3*time.Second).Should(Receive())
+			// the pods are created and deleted one by one, a 'deletecollection' is not sufficient
+			Ω(roleVerbs("pods")).Should(ContainElements("create", "delete"))
+		})
+		It("should delete the failed pod before the pod of the retry is created", func() {
+			cfg.Retry = config.Retry{MaxAttempts: 2, OnFailure: true, Backoff: metav1.Duration{Duration: time.Millisecond}}
+			cache, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			retryPod := pod.DeepCopy()
+			retryPod.Labels = map[string]string{controller.LabelAttempt: "2"}
+			created := make(chan bool, 2)
+			gm.InOrder(
+				mockClient.EXPECT().Create(gm.Any(), pod).Do(func(context.Context, runtime.Object, ...client.CreateOption) {
+					created <- true
+				}),
+				mockClient.EXPECT().Delete(gm.Any(), pod, client.PropagationPolicy(metav1.DeletePropagationBackground)),
+				mockClient.EXPECT().Create(gm.Any(), retryPod).Do(func(context.Context, runtime.Object, ...client.CreateOption) {
+					created <- true
+				}),
+			)
+
+			id, err := cache.NewExecution(configName)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cache.AddPod(&podJob{id: id, jobName: configName, nodeName: "a", attempt: 1, pod: pod, client: mockClient,
+				build: func(attempt int) (*corev1.Pod, error) {
+					Ω(attempt).Should(Equal(2))
+					return retryPod, nil
+				}})).ShouldNot(HaveOccurred())
+			Ω(cache.AllAdded(configName, id)).ShouldNot(HaveOccurred())
+
+			Eventually(created, 3*time.Second).Should(Receive())
+			Ω(cache.PodTerminated(configName, id, "a", 1, corev1.PodFailed)).ShouldNot(HaveOccurred())
+			Eventually(created, 3*time.Second).Should(Receive())
+			// the pod of the failed attempt is deleted with the 'delete' verb
+			Ω(roleVerbs("pods")).Should(ContainElements("create", "delete"))
+		})
+	})
+
+	Context("node selection", func() {
+		BeforeEach(func() {
+			cj.cfg.JobPodTemplate = `
+kind: Pod
+spec:
+  tolerations:
+    - key: tolerated
+      operator: Exists
+`
+			cj.cfg.JobNodeLabelSelector = &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "role", Operator: metav1.LabelSelectorOpIn, Values: []string{"worker", "infra"}},
+					{Key: "gpu", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			}
+			cj.cfg.ExcludedNodes = []string{"c"}
+			cj.cfg.SkipUntoleratedNodes = true
+		})
+		It("should skip excluded nodes and nodes with untolerated taints", func() {
+			var added []string
+			mockCache.EXPECT().ActiveExecutions(configName)
+			mockCache.EXPECT().NewExecution(configName).Return("id", nil)
+			mockCache.EXPECT().AddPod(gm.Any()).Do(func(j lifecycle.Job) {
+				added = append(added, j.Node())
+			}).AnyTimes()
+			mockCache.EXPECT().AllAdded(configName, "id")
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
+			mockClient.EXPECT().Get(gm.Any(), gm.Any(), gm.AssignableToTypeOf(&corev1.Service{}))
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any()).
+				Do(func(ctx context.Context, list *corev1.NodeList, opts ...client.ListOption) error {
+					Ω(opts[0].(client.MatchingLabelsSelector).String()).Should(Equal("!gpu,role in (infra,worker)"))
+					list.Items = []corev1.Node{
+						readyNode("a"),
+						taintedNode("b", corev1.Taint{Key: "tolerated", Effect: corev1.TaintEffectNoSchedule}),
+						readyNode("c"),
+						taintedNode("d", corev1.Taint{Key: "other", Effect: corev1.TaintEffectNoExecute}),
+						taintedNode("e", corev1.Taint{Key: "other", Effect: corev1.TaintEffectPreferNoSchedule}),
+					}
+					return nil
+				})
+
+			cj.startPods()
+			Ω(added).Should(Equal([]string{"a", "b", "e"}))
+		})
+	})
+
+	Context("concurrencyPolicy", func() {
+		var (
+			recorder *record.FakeRecorder
+		)
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			cj.recorder = recorder
+			cj.owner = &corev1.Pod{}
+		})
+		It("should skip the execution with Forbid", func() {
+			cj.cfg.ConcurrencyPolicy = config.ForbidConcurrent
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any())
+			mockCache.EXPECT().ActiveExecutions(configName).Return([]string{"old"}, nil)
+			mockCache.EXPECT().ExecutionOverlapped(configName, lifecycle.OverlapSkipped)
+
+			_, err := cj.trigger(lifecycle.TriggerOptions{})
+			Ω(err).Should(BeAssignableToTypeOf(&lifecycle.ExecutionRunning{}))
+			Ω(recorder.Events).Should(Receive(ContainSubstring("ExecutionSkipped")))
+		})
+		It("should cancel the active execution with Replace", func() {
+			cj.cfg.ConcurrencyPolicy = config.ReplaceConcurrent
+			mockCache.EXPECT().Cancel(configName, "old")
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
+			mockCache.EXPECT().ExecutionOverlapped(configName, lifecycle.OverlapReplaced)
+
+			active, err := cj.applyConcurrencyPolicy(cj.config(), []string{"old"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(active).Should(BeEmpty())
+			Ω(recorder.Events).Should(Receive(ContainSubstring("ExecutionReplaced")))
+		})
+		It("should keep the active execution by default", func() {
+			mockCache.EXPECT().ExecutionOverlapped(configName, lifecycle.OverlapAllowed)
+
+			active, err := cj.applyConcurrencyPolicy(cj.config(), []string{"old"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(active).Should(Equal([]string{"old"}))
+			Ω(recorder.Events).ShouldNot(Receive())
+		})
+	})
+
+	Context("startup", func() {
+		BeforeEach(func() {
+			cj.cfg.CronExpression = "0 * * * *"
+			cj.job = cron.New()
+			cj.entryID, _ = cj.job.AddFunc(cj.cfg.Schedule(), func() {})
+			mockCache.EXPECT().NextSchedule(configName, gm.Any())
+		})
+		It("should record the schedule time on the first start", func() {
+			mockCache.EXPECT().LastScheduled(configName).Return(time.Time{}, nil)
+			mockCache.EXPECT().Scheduled(configName, gm.Any())
+
+			cj.startup()
+		})
+		It("should start the last missed execution within the starting deadline", func() {
+			cj.cfg.StartingDeadline = 3600
+			mockCache.EXPECT().LastScheduled(configName).Return(time.Now().Add(-3*time.Hour), nil)
+			mockCache.EXPECT().Scheduled(configName, gm.Any()).Do(func(_ string, t time.Time) {
+				Ω(t).Should(BeTemporally("~", time.Now(), time.Hour))
+				Ω(t.Minute()).Should(Equal(0))
+			})
+			// the started execution is skipped by the concurrency policy
+			cj.cfg.ConcurrencyPolicy = config.ForbidConcurrent
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any())
+			mockCache.EXPECT().ActiveExecutions(configName).Return([]string{"old"}, nil)
+			mockCache.EXPECT().ExecutionOverlapped(configName, lifecycle.OverlapSkipped)
+
+			cj.startup()
+		})
+		It("should not start missed executions without starting deadline", func() {
+			mockCache.EXPECT().LastScheduled(configName).Return(time.Now().Add(-3*time.Hour), nil)
+
+			cj.startup()
+		})
+		It("should start the pods on startup if configured", func() {
+			cj.cfg.RunOnStartup = true
+			cj.cfg.ConcurrencyPolicy = config.ForbidConcurrent
+			mockCache.EXPECT().LastScheduled(configName).Return(time.Now(), nil)
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NodeList{}), gm.Any())
+			mockCache.EXPECT().ActiveExecutions(configName).Return([]string{"old"}, nil)
+			mockCache.EXPECT().ExecutionOverlapped(configName, lifecycle.OverlapSkipped)
+
+			cj.startup()
+		})
+	})
+
+	Context("missedSchedule", func() {
+		It("should return the latest missed schedule", func() {
+			last := time.Date(2020, 1, 1, 10, 30, 0, 0, time.Local)
+			missed, count, err := missedSchedule("0 * * * *", last, last.Add(3*time.Hour))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(count).Should(Equal(3))
+			Ω(missed).Should(Equal(time.Date(2020, 1, 1, 13, 0, 0, 0, time.Local)))
+		})
+		It("should return nothing if no schedule was missed", func() {
+			last := time.Date(2020, 1, 1, 10, 30, 0, 0, time.Local)
+			missed, count, err := missedSchedule("0 * * * *", last, last.Add(time.Minute))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(count).Should(BeZero())
+			Ω(missed.IsZero()).Should(BeTrue())
+		})
+		It("should fail with an invalid expression", func() {
+			_, _, err := missedSchedule("invalid", time.Now(), time.Now())
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("cancel", func() {
+		It("should cancel the execution and delete the running pods", func() {
+			mockCache.EXPECT().Cancel(configName, "id")
+			mockClient.EXPECT().DeleteAllOf(gm.Any(), gm.AssignableToTypeOf(&corev1.Pod{}), client.InNamespace(namespace),
+				client.MatchingLabels{controller.LabelOwner: configName, controller.LabelExecutionID: "id"}, gm.Any(), gm.Any())
+
+			Ω(cj.cancel("id")).ShouldNot(HaveOccurred())
+		})
+		It("should not delete pods if the execution is unknown", func() {
+			mockCache.EXPECT().Cancel(configName, "id").Return(&lifecycle.ExecutionIDNotFound{Err: fmt.Errorf("not found")})
+
+			Ω(cj.cancel("id")).Should(HaveOccurred())
+		})
+	})
+
+	Context("scheduler.InjectConfig", func() {
+		var (
+			s *scheduler
+		)
+		BeforeEach(func() {
+			s = &scheduler{
+				namespace: namespace,
+				client:    mockClient,
+				cache:     mockCache,
+				cron:      cron.New(),
+				jobs:      make(map[string]*cronJob),
+			}
+			s.jobs["a"] = s.newCronJob(&config.Config{Name: "a", CronExpression: "* * * * *"})
+			s.jobs["b"] = s.newCronJob(&config.Config{Name: "b", CronExpression: "* * * * *"})
+		})
+		It("should add and remove jobs", func() {
+			mockCache.EXPECT().NextSchedule("a", gm.Any())
+			s.InjectConfig(&config.Config{
+				Jobs: []config.Config{
+					{Name: "a", CronExpression: "0 * * * *"},
+					{Name: "c", CronExpression: "* * * * *"},
+				},
+			})
+			Ω(s.jobs).Should(HaveLen(2))
+			Ω(s.jobs).Should(HaveKey("a"))
+			Ω(s.jobs).Should(HaveKey("c"))
+			Ω(s.jobs["a"].config().CronExpression).Should(Equal("0 * * * *"))
+			Ω(s.cron.Entries()).Should(HaveLen(2))
+		})
+	})
 })
+
+type failingMutator struct{}
+
+func (m *failingMutator) MutatePod(cfg *config.Config, target job.Target, id string, pod *corev1.Pod) error {
+	return fmt.Errorf("mutation failed")
+}
+
+func readyNode(name string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{
+					Type:   corev1.NodeReady,
+					Status: corev1.ConditionTrue,
+				},
+			},
+		},
+	}
+}
+
+func taintedNode(name string, taint corev1.Taint) corev1.Node {
+	n := readyNode(name)
+	n.Spec.Taints = []corev1.Taint{taint}
+	return n
+}
+
+// roleVerbs get the verbs the role of the helm chart grants on the resource
+func roleVerbs(resource string) []string {
+	b, err := ioutil.ReadFile("../../helm/example-batch-job-controller/templates/rbac.yaml")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	var verbs []string
+	for _, doc := range strings.Split(string(b), "\n---") {
+		// the rules do not contain template directives, the other lines are dropped to get valid yaml
+		var lines []string
+		for _, l := range strings.Split(doc, "\n") {
+			if !strings.Contains(l, "{{") {
+				lines = append(lines, l)
+			}
+		}
+		role := &rbacv1.Role{}
+		Ω(yaml.Unmarshal([]byte(strings.Join(lines, "\n")), role)).ShouldNot(HaveOccurred())
+		if role.Kind != "Role" {
+			continue
+		}
+		for _, rule := range role.Rules {
+			for _, r := range rule.Resources {
+				if r == resource {
+					verbs = append(verbs, rule.Verbs...)
+				}
+			}
+		}
+	}
+	return verbs
+}
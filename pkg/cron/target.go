@@ -0,0 +1,177 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/job"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TargetProvider provides the targets to start the job pods for
+type TargetProvider interface {
+	// Targets get the targets of an execution of the job matching the trigger options
+	Targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error)
+}
+
+// TargetFilter filter the targets of each execution in a custom implementation
+type TargetFilter interface {
+	// FilterTargets get the targets to start the pods of the next execution of the job for.
+	// Targets can be removed or added, an error prevents the execution from being started
+	FilterTargets(cfg *config.Config, targets []job.Target) ([]job.Target, error)
+}
+
+// targetProvider get the provider of the target kind of the job
+func (j *cronJob) targetProvider(cfg *config.Config) (TargetProvider, error) {
+	switch cfg.TargetKind() {
+	case config.TargetKindNode:
+		return &nodeTargets{client: j.client}, nil
+	case config.TargetKindNamespace:
+		return &namespaceTargets{client: j.client}, nil
+	case config.TargetKindPersistentVolume:
+		return &persistentVolumeTargets{client: j.client}, nil
+	case config.TargetKindStatic:
+		return &staticTargets{}, nil
+	}
+	return nil, fmt.Errorf("unknown target kind %q", cfg.TargetKind())
+}
+
+// nodeTargets one target per usable node matching the node selector of the job
+type nodeTargets struct {
+	client client.Reader
+}
+
+func (p *nodeTargets) Targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error) {
+	selector, err := cfg.NodeSelector()
+	if err != nil {
+		return nil, err
+	}
+	if selector, err = withTriggerSelector(selector, opts); err != nil {
+		return nil, err
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := p.client.List(context.TODO(), nodeList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var targets []job.Target
+	for _, n := range nodeList.Items {
+		if isUsable(n, cfg.RunOnUnscheduledNodes) {
+			targets = append(targets, job.NodeTarget(n))
+		}
+	}
+	return targets, nil
+}
+
+// namespaceTargets one target per active namespace matching the target label selector
+type namespaceTargets struct {
+	client client.Reader
+}
+
+func (p *namespaceTargets) Targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error) {
+	selector, err := targetSelector(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nsList := &corev1.NamespaceList{}
+	if err := p.client.List(context.TODO(), nsList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var targets []job.Target
+	for _, ns := range nsList.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		targets = append(targets, job.Target{
+			Name:        ns.Name,
+			Kind:        config.TargetKindNamespace,
+			Labels:      ns.Labels,
+			Annotations: ns.Annotations,
+		})
+	}
+	return targets, nil
+}
+
+// persistentVolumeTargets one target per persistent volume matching the target label selector
+type persistentVolumeTargets struct {
+	client client.Reader
+}
+
+func (p *persistentVolumeTargets) Targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error) {
+	selector, err := targetSelector(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pvList := &corev1.PersistentVolumeList{}
+	if err := p.client.List(context.TODO(), pvList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var targets []job.Target
+	for _, pv := range pvList.Items {
+		data := map[string]string{
+			"phase":        string(pv.Status.Phase),
+			"storageClass": pv.Spec.StorageClassName,
+		}
+		if ref := pv.Spec.ClaimRef; ref != nil {
+			data["claimNamespace"] = ref.Namespace
+			data["claimName"] = ref.Name
+		}
+		targets = append(targets, job.Target{
+			Name:        pv.Name,
+			Kind:        config.TargetKindPersistentVolume,
+			Labels:      pv.Labels,
+			Annotations: pv.Annotations,
+			Data:        data,
+		})
+	}
+	return targets, nil
+}
+
+// staticTargets one target per item of the target config
+type staticTargets struct{}
+
+func (p *staticTargets) Targets(cfg *config.Config, opts lifecycle.TriggerOptions) ([]job.Target, error) {
+	if opts.NodeSelector != "" {
+		return nil, fmt.Errorf("a selector can not be applied to static targets")
+	}
+	var targets []job.Target
+	for _, item := range cfg.Target.Items {
+		targets = append(targets, job.Target{
+			Name: item,
+			Kind: config.TargetKindStatic,
+		})
+	}
+	return targets, nil
+}
+
+// targetSelector get the selector of the target config combined with the selector of the trigger
+func targetSelector(cfg *config.Config, opts lifecycle.TriggerOptions) (labels.Selector, error) {
+	selector := labels.Everything()
+	if cfg.Target.LabelSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(cfg.Target.LabelSelector); err != nil {
+			return nil, err
+		}
+	}
+	return withTriggerSelector(selector, opts)
+}
+
+// withTriggerSelector add the requirements of the trigger selector, the targets must match both selectors
+func withTriggerSelector(selector labels.Selector, opts lifecycle.TriggerOptions) (labels.Selector, error) {
+	if opts.NodeSelector == "" {
+		return selector, nil
+	}
+	triggerSelector, err := labels.Parse(opts.NodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %v", opts.NodeSelector, err)
+	}
+	reqs, _ := triggerSelector.Requirements()
+	return selector.Add(reqs...), nil
+}
@@ -0,0 +1,170 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/job"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	mock_client "github.com/bakito/batch-job-controller/pkg/mocks/client"
+	gm "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Target", func() {
+	var (
+		cj         *cronJob
+		mockCtrl   *gm.Controller //gomock struct
+		mockClient *mock_client.MockClient
+		cfg        *config.Config
+	)
+	BeforeEach(func() {
+		mockCtrl = gm.NewController(GinkgoT())
+		mockClient = mock_client.NewMockClient(mockCtrl)
+		cj = &cronJob{client: mockClient}
+		cfg = &config.Config{Name: "job"}
+	})
+
+	It("should use the nodes by default", func() {
+		p, err := cj.targetProvider(cfg)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(p).Should(BeAssignableToTypeOf(&nodeTargets{}))
+	})
+
+	Context("namespace", func() {
+		BeforeEach(func() {
+			cfg.Target = config.Target{
+				Kind:          config.TargetKindNamespace,
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			}
+		})
+		It("should return the active namespaces", func() {
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.NamespaceList{}), gm.Any()).
+				Do(func(ctx context.Context, list *corev1.NamespaceList, opts ...client.ListOption) error {
+					Ω(opts[0].(client.MatchingLabelsSelector).String()).Should(Equal("env=prod,team=a"))
+					list.Items = []corev1.Namespace{
+						{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"team": "a"}}},
+						{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating}},
+					}
+					return nil
+				})
+
+			p, err := cj.targetProvider(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			targets, err := p.Targets(cfg, lifecycle.TriggerOptions{NodeSelector: "env=prod"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(targets).Should(Equal([]job.Target{
+				{Name: "a", Kind: config.TargetKindNamespace, Labels: map[string]string{"team": "a"}},
+			}))
+		})
+	})
+
+	Context("persistentVolume", func() {
+		BeforeEach(func() {
+			cfg.Target = config.Target{Kind: config.TargetKindPersistentVolume}
+		})
+		It("should return the persistent volumes with their claim", func() {
+			mockClient.EXPECT().List(gm.Any(), gm.AssignableToTypeOf(&corev1.PersistentVolumeList{}), gm.Any()).
+				Do(func(ctx context.Context, list *corev1.PersistentVolumeList, opts ...client.ListOption) error {
+					list.Items = []corev1.PersistentVolume{
+						{
+							ObjectMeta: metav1.ObjectMeta{Name: "pv-a"},
+							Spec: corev1.PersistentVolumeSpec{
+								StorageClassName: "fast",
+								ClaimRef:         &corev1.ObjectReference{Namespace: "ns", Name: "data"},
+							},
+							Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+						},
+					}
+					return nil
+				})
+
+			p, err := cj.targetProvider(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			targets, err := p.Targets(cfg, lifecycle.TriggerOptions{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(targets).Should(HaveLen(1))
+			Ω(targets[0].Name).Should(Equal("pv-a"))
+			Ω(targets[0].NodeName).Should(BeEmpty())
+			Ω(targets[0].Data).Should(Equal(map[string]string{
+				"phase":          "Bound",
+				"storageClass":   "fast",
+				"claimNamespace": "ns",
+				"claimName":      "data",
+			}))
+		})
+	})
+
+	Context("static", func() {
+		BeforeEach(func() {
+			cfg.Target = config.Target{Kind: config.TargetKindStatic, Items: []string{"x", "y"}}
+		})
+		It("should return the configured items", func() {
+			p, err := cj.targetProvider(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			targets, err := p.Targets(cfg, lifecycle.TriggerOptions{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(targets).Should(Equal([]job.Target{
+				{Name: "x", Kind: config.TargetKindStatic},
+				{Name: "y", Kind: config.TargetKindStatic},
+			}))
+		})
+		It("should fail with a selector", func() {
+			p, err := cj.targetProvider(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = p.Targets(cfg, lifecycle.TriggerOptions{NodeSelector: "foo=bar"})
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should restrict the targets to the triggered names", func() {
+			targets, err := cj.targets(cfg, lifecycle.TriggerOptions{Nodes: []string{"y"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(targets).Should(HaveLen(1))
+			Ω(targets[0].Name).Should(Equal("y"))
+		})
+	})
+
+	Context("filter", func() {
+		BeforeEach(func() {
+			cfg.Target = config.Target{Kind: config.TargetKindStatic, Items: []string{"x", "y"}}
+		})
+		It("should apply the filters in order", func() {
+			cj.filters = []TargetFilter{
+				filterFunc(func(cfg *config.Config, targets []job.Target) ([]job.Target, error) {
+					return append(targets[1:], job.Target{Name: "z", Kind: "custom"}), nil
+				}),
+				filterFunc(func(cfg *config.Config, targets []job.Target) ([]job.Target, error) {
+					return append(targets, job.Target{Name: "excluded"}), nil
+				}),
+			}
+			cfg.ExcludedNodes = []string{"excluded"}
+
+			targets, err := cj.targets(cfg, lifecycle.TriggerOptions{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(targets).Should(Equal([]job.Target{
+				{Name: "y", Kind: config.TargetKindStatic},
+				{Name: "z", Kind: "custom"},
+			}))
+		})
+		It("should fail if a filter fails", func() {
+			cj.filters = []TargetFilter{
+				filterFunc(func(cfg *config.Config, targets []job.Target) ([]job.Target, error) {
+					return nil, fmt.Errorf("vetoed")
+				}),
+			}
+
+			_, err := cj.targets(cfg, lifecycle.TriggerOptions{})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
+
+type filterFunc func(cfg *config.Config, targets []job.Target) ([]job.Target, error)
+
+func (f filterFunc) FilterTargets(cfg *config.Config, targets []job.Target) ([]job.Target, error) {
+	return f(cfg, targets)
+}
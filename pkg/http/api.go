@@ -0,0 +1,177 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// APIBasePath base path of the execution api
+	APIBasePath = "/api"
+	// APIExecutionsPath path to list the executions of all jobs
+	APIExecutionsPath = "/executions"
+	// APIJobExecutionsPath path to list the executions of a job
+	APIJobExecutionsPath = "/jobs/{job}/executions"
+	// APIExecutionPath path of an execution
+	APIExecutionPath = APIJobExecutionsPath + "/{executionID}"
+	// APINodePath path of a node within an execution
+	APINodePath = APIExecutionPath + "/nodes/{node}"
+	// APICancelPath path to cancel an execution
+	APICancelPath = APIExecutionPath + "/cancel"
+
+	// EnvAPIToken env variable of the bearer token required to trigger and cancel executions
+	EnvAPIToken = "API_TOKEN"
+
+	errorAPITokenNotConfigured = "trigger and cancel are disabled, no api token is configured"
+	errorAPIUnauthorized       = "invalid or missing bearer token"
+)
+
+// setupAPI register the read only execution api
+func (s *PostServer) setupAPI(r *mux.Router) {
+	api := r.PathPrefix(APIBasePath).Subrouter()
+	api.HandleFunc(APIExecutionsPath, s.getExecutions).Methods("GET")
+	api.HandleFunc(APIJobExecutionsPath, s.getJobExecutions).Methods("GET")
+	api.HandleFunc(APIExecutionPath, s.getExecution).Methods("GET")
+	api.HandleFunc(APINodePath, s.getNode).Methods("GET")
+
+	api.Handle(APIJobExecutionsPath, s.authenticated(http.HandlerFunc(s.triggerExecution))).Methods("POST")
+	api.Handle(APICancelPath, s.authenticated(http.HandlerFunc(s.cancelExecution))).Methods("POST")
+}
+
+// authenticated allow only requests with the configured bearer token
+func (s *PostServer) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.APIToken == "" {
+			http.Error(w, errorAPITokenNotConfigured, http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) != 1 {
+			http.Error(w, errorAPIUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *PostServer) triggerExecution(w http.ResponseWriter, r *http.Request) {
+	jobName, _, _ := s.jobNodeAndID(r)
+
+	opts := lifecycle.TriggerOptions{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding trigger options: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := labels.Parse(opts.NodeSelector); err != nil {
+		http.Error(w, fmt.Sprintf("invalid node selector: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if s.Scheduler == nil {
+		http.Error(w, "no scheduler available", http.StatusServiceUnavailable)
+		return
+	}
+
+	executionID, err := s.Scheduler.Trigger(jobName, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Info("execution triggered", "job", jobName, "id", executionID, "nodes", opts.Nodes, "nodeSelector", opts.NodeSelector)
+
+	writeJSON(w, http.StatusCreated, lifecycle.ExecutionInfo{Job: jobName, ExecutionID: executionID})
+}
+
+func (s *PostServer) cancelExecution(w http.ResponseWriter, r *http.Request) {
+	jobName, _, executionID := s.jobNodeAndID(r)
+	if s.Scheduler == nil {
+		http.Error(w, "no scheduler available", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.Scheduler.Cancel(jobName, executionID); err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Info("execution cancelled", "job", jobName, "id", executionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *PostServer) getExecutions(w http.ResponseWriter, r *http.Request) {
+	executions := []lifecycle.ExecutionInfo{}
+	cfg := s.config()
+	if cfg != nil {
+		for _, jc := range cfg.JobConfigs() {
+			infos, err := s.Cache.Executions(jc.Name)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			executions = append(executions, infos...)
+		}
+	}
+	writeJSON(w, http.StatusOK, executions)
+}
+
+func (s *PostServer) getJobExecutions(w http.ResponseWriter, r *http.Request) {
+	jobName, _, _ := s.jobNodeAndID(r)
+	executions, err := s.Cache.Executions(jobName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if executions == nil {
+		executions = []lifecycle.ExecutionInfo{}
+	}
+	writeJSON(w, http.StatusOK, executions)
+}
+
+func (s *PostServer) getExecution(w http.ResponseWriter, r *http.Request) {
+	jobName, _, executionID := s.jobNodeAndID(r)
+	execution, err := s.Cache.Execution(jobName, executionID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, execution)
+}
+
+func (s *PostServer) getNode(w http.ResponseWriter, r *http.Request) {
+	jobName, nodeName, executionID := s.jobNodeAndID(r)
+	node, err := s.Cache.Node(jobName, executionID, nodeName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error(err, "error encoding response")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var notFound *lifecycle.ExecutionIDNotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var running *lifecycle.ExecutionRunning
+	if errors.As(err, &running) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
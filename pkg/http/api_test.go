@@ -0,0 +1,188 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/bakito/batch-job-controller/pkg/lifecycle"
+	mock_cache "github.com/bakito/batch-job-controller/pkg/mocks/cache"
+	mock_logr "github.com/bakito/batch-job-controller/pkg/mocks/logr"
+	gm "github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API", func() {
+	var (
+		mockCtrl  *gm.Controller //gomock struct
+		mockCache *mock_cache.MockCache
+		s         *PostServer
+		rr        *httptest.ResponseRecorder
+		router    *mux.Router
+	)
+	BeforeEach(func() {
+		mockCtrl = gm.NewController(GinkgoT())
+		mockCache = mock_cache.NewMockCache(mockCtrl)
+		mockLog := mock_logr.NewMockLogger(mockCtrl)
+		mockLog.EXPECT().Info(gm.Any(), gm.Any()).AnyTimes()
+		log = mockLog
+		s = &PostServer{}
+		s.InjectCache(mockCache)
+		s.InjectConfig(&config.Config{Jobs: []config.Config{{Name: "a"}, {Name: "b"}}})
+
+		rr = httptest.NewRecorder()
+		router = mux.NewRouter()
+		s.setupAPI(router)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	get := func(path string) {
+		req, err := http.NewRequest("GET", path, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		router.ServeHTTP(rr, req)
+	}
+	post := func(path string, token string, body string) {
+		req, err := http.NewRequest("POST", path, strings.NewReader(body))
+		Ω(err).ShouldNot(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(rr, req)
+	}
+
+	It("should list the executions of all jobs", func() {
+		mockCache.EXPECT().Executions("a").Return([]lifecycle.ExecutionInfo{{Job: "a", ExecutionID: "1"}}, nil)
+		mockCache.EXPECT().Executions("b").Return([]lifecycle.ExecutionInfo{{Job: "b", ExecutionID: "2"}}, nil)
+
+		get("/api/executions")
+
+		Ω(rr.Code).Should(Equal(http.StatusOK))
+		var infos []lifecycle.ExecutionInfo
+		Ω(json.Unmarshal(rr.Body.Bytes(), &infos)).ShouldNot(HaveOccurred())
+		Ω(infos).Should(HaveLen(2))
+		Ω(infos[1].Job).Should(Equal("b"))
+	})
+	It("should return an empty list if a job has no executions", func() {
+		mockCache.EXPECT().Executions("a").Return(nil, nil)
+
+		get("/api/jobs/a/executions")
+
+		Ω(rr.Code).Should(Equal(http.StatusOK))
+		Ω(rr.Body.String()).Should(Equal("[]\n"))
+	})
+	It("should return an execution", func() {
+		mockCache.EXPECT().Execution("a", "1").Return(&lifecycle.ExecutionInfo{Job: "a", ExecutionID: "1", Files: []string{"node.json"}}, nil)
+
+		get("/api/jobs/a/executions/1")
+
+		Ω(rr.Code).Should(Equal(http.StatusOK))
+		Ω(rr.Header().Get("Content-Type")).Should(Equal("application/json"))
+		info := &lifecycle.ExecutionInfo{}
+		Ω(json.Unmarshal(rr.Body.Bytes(), info)).ShouldNot(HaveOccurred())
+		Ω(info.Files).Should(Equal([]string{"node.json"}))
+	})
+	It("should return the node of an execution", func() {
+		mockCache.EXPECT().Node("a", "1", "node").Return(&lifecycle.NodeInfo{Node: "node", Status: "Succeeded"}, nil)
+
+		get("/api/jobs/a/executions/1/nodes/node")
+
+		Ω(rr.Code).Should(Equal(http.StatusOK))
+		info := &lifecycle.NodeInfo{}
+		Ω(json.Unmarshal(rr.Body.Bytes(), info)).ShouldNot(HaveOccurred())
+		Ω(info.Status).Should(Equal("Succeeded"))
+	})
+	It("should return not found for unknown executions", func() {
+		mockCache.EXPECT().Execution("a", "2").Return(nil, &lifecycle.ExecutionIDNotFound{Err: fmt.Errorf("not found")})
+
+		get("/api/jobs/a/executions/2")
+
+		Ω(rr.Code).Should(Equal(http.StatusNotFound))
+	})
+
+	Context("trigger and cancel", func() {
+		var (
+			scheduler *testScheduler
+		)
+		BeforeEach(func() {
+			scheduler = &testScheduler{}
+			s.APIToken = "secret"
+			s.InjectScheduler(scheduler)
+		})
+		It("should trigger an execution", func() {
+			post("/api/jobs/a/executions", "secret", `{"nodes": ["node-a"], "nodeSelector": "foo=bar"}`)
+
+			Ω(rr.Code).Should(Equal(http.StatusCreated))
+			info := &lifecycle.ExecutionInfo{}
+			Ω(json.Unmarshal(rr.Body.Bytes(), info)).ShouldNot(HaveOccurred())
+			Ω(info.ExecutionID).Should(Equal("id"))
+			Ω(scheduler.job).Should(Equal("a"))
+			Ω(scheduler.opts.Nodes).Should(Equal([]string{"node-a"}))
+			Ω(scheduler.opts.NodeSelector).Should(Equal("foo=bar"))
+		})
+		It("should trigger an execution without options", func() {
+			post("/api/jobs/a/executions", "secret", "")
+
+			Ω(rr.Code).Should(Equal(http.StatusCreated))
+			Ω(scheduler.opts).Should(Equal(lifecycle.TriggerOptions{}))
+		})
+		It("should reject an invalid node selector", func() {
+			post("/api/jobs/a/executions", "secret", `{"nodeSelector": "foo in (bar"}`)
+
+			Ω(rr.Code).Should(Equal(http.StatusBadRequest))
+			Ω(scheduler.job).Should(BeEmpty())
+		})
+		It("should return conflict if the job is still starting", func() {
+			scheduler.err = &lifecycle.ExecutionRunning{Err: fmt.Errorf("running")}
+			post("/api/jobs/a/executions", "secret", "")
+
+			Ω(rr.Code).Should(Equal(http.StatusConflict))
+		})
+		It("should deny requests without a valid token", func() {
+			post("/api/jobs/a/executions", "", "")
+			Ω(rr.Code).Should(Equal(http.StatusUnauthorized))
+
+			rr = httptest.NewRecorder()
+			post("/api/jobs/a/executions/id/cancel", "wrong", "")
+			Ω(rr.Code).Should(Equal(http.StatusUnauthorized))
+			Ω(scheduler.job).Should(BeEmpty())
+		})
+		It("should be disabled without a token", func() {
+			s.APIToken = ""
+			post("/api/jobs/a/executions", "", "")
+			Ω(rr.Code).Should(Equal(http.StatusForbidden))
+		})
+		It("should cancel an execution", func() {
+			post("/api/jobs/a/executions/id/cancel", "secret", "")
+
+			Ω(rr.Code).Should(Equal(http.StatusNoContent))
+			Ω(scheduler.job).Should(Equal("a"))
+			Ω(scheduler.cancelled).Should(Equal("id"))
+		})
+	})
+})
+
+type testScheduler struct {
+	job       string
+	opts      lifecycle.TriggerOptions
+	cancelled string
+	err       error
+}
+
+func (s *testScheduler) Trigger(jobName string, opts lifecycle.TriggerOptions) (string, error) {
+	s.job = jobName
+	s.opts = opts
+	return "id", s.err
+}
+
+func (s *testScheduler) Cancel(jobName string, executionID string) error {
+	s.job = jobName
+	s.cancelled = executionID
+	return s.err
+}
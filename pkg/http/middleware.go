@@ -5,14 +5,14 @@ import (
 )
 
 const (
-	errorMiddlewareNotAcceptable = "node / execution ID not allowed"
+	errorMiddlewareNotAcceptable = "job / node / execution ID not allowed"
 )
 
 func (s *PostServer) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if s.Cache != nil {
 
-			if !s.Cache.Has(s.nodeAndID(r)) {
+			if !s.Cache.Has(s.jobNodeAndID(r)) {
 				http.Error(w, errorMiddlewareNotAcceptable, http.StatusNotAcceptable)
 				return
 			}
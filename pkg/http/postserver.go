@@ -3,12 +3,15 @@ package http
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"net/http/pprof"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/lifecycle"
@@ -23,7 +26,7 @@ import (
 
 const (
 	// CallbackBasePath callback path
-	CallbackBasePath = "/report/{node}/{executionID}"
+	CallbackBasePath = "/report/{job}/{node}/{executionID}"
 	// CallbackBaseResultSubPath result sub path
 	CallbackBaseResultSubPath = "/result"
 	// CallbackBaseFileSubPath file sub path
@@ -39,8 +42,8 @@ var (
 	log = ctrl.Log.WithName("http-server")
 )
 
-//GenericAPIServer prepare the generic api server
-func GenericAPIServer(port int, reportPath string) manager.Runnable {
+// GenericAPIServer prepare the generic api server
+func GenericAPIServer(port int) manager.Runnable {
 
 	r := mux.NewRouter()
 	s := &PostServer{
@@ -49,12 +52,11 @@ func GenericAPIServer(port int, reportPath string) manager.Runnable {
 			Kind:    "internal",
 			Handler: r,
 		},
-		ReportPath: reportPath,
+		APIToken: os.Getenv(EnvAPIToken),
 	}
 
-	r.Use(s.middleware)
-
 	rep := r.PathPrefix(CallbackBasePath).Subrouter()
+	rep.Use(s.middleware)
 
 	rep.HandleFunc(CallbackBaseResultSubPath, s.postReport).
 		Methods("POST").
@@ -73,6 +75,13 @@ func GenericAPIServer(port int, reportPath string) manager.Runnable {
 		"path", fmt.Sprintf("%s/%s", CallbackBasePath, CallbackBaseResultSubPath),
 	)
 
+	s.setupAPI(r)
+	log.Info("starting api",
+		"port", port,
+		"method", "GET",
+		"path", APIBasePath,
+	)
+
 	SetupProfiling(r)
 
 	return s
@@ -97,10 +106,12 @@ func SetupProfiling(r *mux.Router) {
 type PostServer struct {
 	Server
 	Cache         lifecycle.Cache
-	ReportPath    string
 	EventRecorder record.EventRecorder
 	Config        *config.Config
 	Client        client.Reader
+	Scheduler     lifecycle.Scheduler
+	APIToken      string
+	configLock    sync.RWMutex
 }
 
 func (s *PostServer) InjectEventRecorder(er record.EventRecorder) {
@@ -115,23 +126,44 @@ func (s *PostServer) InjectReader(reader client.Reader) {
 	s.Client = reader
 }
 
+func (s *PostServer) InjectScheduler(scheduler lifecycle.Scheduler) {
+	s.Scheduler = scheduler
+}
+
 func (s *PostServer) InjectConfig(cfg *config.Config) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
 	s.Config = cfg
 }
 
+func (s *PostServer) config() *config.Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.Config
+}
+
 func (s *PostServer) postReport(w http.ResponseWriter, r *http.Request) {
 
 	buf := new(bytes.Buffer)
 	_, _ = buf.ReadFrom(r.Body)
 
-	node, executionID := s.nodeAndID(r)
+	jobName, node, executionID := s.jobNodeAndID(r)
 
 	postLog := log.WithValues(
+		"job", jobName,
 		"node", node,
 		"id", executionID,
 		"length", len(buf.Bytes()),
 	)
 
+	cfg := s.jobConfig(jobName)
+	if cfg == nil {
+		err := fmt.Errorf("job %q not found", jobName)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		postLog.Error(err, "")
+		return
+	}
+
 	results := new(lifecycle.Results)
 
 	err := json.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&results)
@@ -141,14 +173,19 @@ func (s *PostServer) postReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = results.Validate(s.Config)
+	err = results.Validate(cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		var invalid *lifecycle.ValidationError
+		if errors.As(err, &invalid) {
+			writeJSON(w, http.StatusBadRequest, invalid)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		postLog.Error(err, "results is invalid")
 		return
 	}
 
-	fileName, err := s.SaveFile(executionID, fmt.Sprintf("%s.json", node), buf.Bytes())
+	fileName, err := s.SaveFile(jobName, executionID, fmt.Sprintf("%s.json", node), buf.Bytes())
 	postLog = postLog.WithValues(
 		"name", filepath.Base(fileName),
 		"path", fileName,
@@ -158,7 +195,7 @@ func (s *PostServer) postReport(w http.ResponseWriter, r *http.Request) {
 		postLog.Error(err, "error receiving file")
 		return
 	}
-	s.Cache.ReportReceived(executionID, node, err, *results)
+	s.Cache.ReportReceived(jobName, executionID, node, err, *results)
 	postLog.Info("received report")
 }
 
@@ -176,11 +213,12 @@ func (s *PostServer) postFile(w http.ResponseWriter, r *http.Request) {
 
 		fileName += s.evaluateExtension(r)
 	}
-	node, executionID := s.nodeAndID(r)
+	jobName, node, executionID := s.jobNodeAndID(r)
 
 	var err error
-	fileName, err = s.SaveFile(executionID, fmt.Sprintf("%s-%s", node, fileName), buf.Bytes())
+	fileName, err = s.SaveFile(jobName, executionID, fmt.Sprintf("%s-%s", node, fileName), buf.Bytes())
 	postLog := log.WithValues(
+		"job", jobName,
 		"node", node,
 		"id", executionID,
 		"name", filepath.Base(fileName),
@@ -200,9 +238,10 @@ func (s *PostServer) postEvent(w http.ResponseWriter, r *http.Request) {
 	buf := new(bytes.Buffer)
 	_, _ = buf.ReadFrom(r.Body)
 
-	node, executionID := s.nodeAndID(r)
+	jobName, node, executionID := s.jobNodeAndID(r)
 
 	postLog := log.WithValues(
+		"job", jobName,
 		"node", node,
 		"id", executionID,
 		"length", len(buf.Bytes()),
@@ -222,10 +261,17 @@ func (s *PostServer) postEvent(w http.ResponseWriter, r *http.Request) {
 		postLog.Error(err, "event is invalid")
 		return
 	}
-	podName := s.Config.PodName(node, executionID)
+	cfg := s.jobConfig(jobName)
+	if cfg == nil {
+		err = fmt.Errorf("job %q not found", jobName)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		postLog.Error(err, "")
+		return
+	}
+	podName := cfg.PodName(node, executionID)
 
 	pod := &corev1.Pod{}
-	err = s.Client.Get(r.Context(), client.ObjectKey{Namespace: s.Config.Namespace, Name: podName}, pod)
+	err = s.Client.Get(r.Context(), client.ObjectKey{Namespace: cfg.Namespace, Name: podName}, pod)
 
 	if err != nil {
 		err = fmt.Errorf("error finding pod: %v", err)
@@ -241,11 +287,20 @@ func (s *PostServer) postEvent(w http.ResponseWriter, r *http.Request) {
 	postLog.Info("event created")
 }
 
-func (s *PostServer) nodeAndID(r *http.Request) (string, string) {
+func (s *PostServer) jobNodeAndID(r *http.Request) (string, string, string) {
 	vars := mux.Vars(r)
+	jobName := vars["job"]
 	node := vars["node"]
 	executionID := vars["executionID"]
-	return node, executionID
+	return jobName, node, executionID
+}
+
+func (s *PostServer) jobConfig(jobName string) *config.Config {
+	cfg := s.config()
+	if cfg == nil {
+		return nil
+	}
+	return cfg.JobConfig(jobName)
 }
 
 func (s *PostServer) evaluateExtension(r *http.Request) string {
@@ -262,8 +317,12 @@ func (s *PostServer) evaluateExtension(r *http.Request) string {
 	return ".file"
 }
 
-// SaveFile save a received file
-func (s *PostServer) SaveFile(executionID, name string, data []byte) (string, error) {
-	fileName := filepath.Join(s.ReportPath, executionID, name)
+// SaveFile save a received file into the report directory of the job
+func (s *PostServer) SaveFile(jobName, executionID, name string, data []byte) (string, error) {
+	cfg := s.jobConfig(jobName)
+	if cfg == nil {
+		return "", fmt.Errorf("job %q not found", jobName)
+	}
+	fileName := filepath.Join(cfg.ReportDirectory, executionID, name)
 	return fileName, ioutil.WriteFile(fileName, data, 0644)
 }
@@ -39,6 +39,7 @@ var _ = Describe("HTTP", func() {
 		mockReader  *mock_client.MockReader
 		executionID string
 		node        string
+		jobName     string
 
 		s   *PostServer
 		cfg *config.Config
@@ -55,15 +56,19 @@ var _ = Describe("HTTP", func() {
 		mockCache = mock_cache.NewMockCache(mockCtrl)
 		executionID = uuid.New().String()
 		node = uuid.New().String()
+		jobName = uuid.New().String()
 		cfg = &config.Config{
+			Name:            jobName,
+			ReportDirectory: tempDir(executionID),
 			Metrics: config.Metrics{
 				Prefix: "foo",
+				Gauges: map[string]config.Metric{
+					"test": {Labels: []string{"label_a", "label_b"}},
+				},
 			},
 		}
 
-		s = &PostServer{
-			ReportPath: tempDir(executionID),
-		}
+		s = &PostServer{}
 		s.InjectReader(mockReader)
 		s.InjectCache(mockCache)
 		s.InjectConfig(cfg)
@@ -72,20 +77,20 @@ var _ = Describe("HTTP", func() {
 
 		// Need to create a router that we can pass the request through so that the vars will be added to the context
 		router = mux.NewRouter()
-		path = fmt.Sprintf("/report/%s/%s%s", node, executionID, CallbackBaseResultSubPath)
+		path = fmt.Sprintf("/report/%s/%s/%s%s", jobName, node, executionID, CallbackBaseResultSubPath)
 	})
 	AfterEach(func() {
-		os.RemoveAll(s.ReportPath)
+		os.RemoveAll(cfg.ReportDirectory)
 	})
 	Context("postReport", func() {
 		BeforeEach(func() {
 			router.HandleFunc(CallbackBasePath+CallbackBaseResultSubPath, s.postReport)
 
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 		})
 		It("succeed if file is saved", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
 			mockLog.EXPECT().WithValues("name", gm.Any(), "path", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Info("received report")
 
@@ -96,17 +101,17 @@ var _ = Describe("HTTP", func() {
 
 			Ω(rr.Code).Should(Equal(http.StatusOK))
 
-			files, err := ioutil.ReadDir(filepath.Join(s.ReportPath, executionID))
+			files, err := ioutil.ReadDir(filepath.Join(cfg.ReportDirectory, executionID))
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(files).Should(HaveLen(1))
 
-			b, err := ioutil.ReadFile(filepath.Join(s.ReportPath, executionID, files[0].Name()))
+			b, err := ioutil.ReadFile(filepath.Join(cfg.ReportDirectory, executionID, files[0].Name()))
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(b).Should(Equal([]byte(reportJSON)))
 		})
 		It("fails if json is invalid", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
 			mockLog.EXPECT().WithValues("result", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Error(gm.Any(), gm.Any())
 
@@ -117,10 +122,55 @@ var _ = Describe("HTTP", func() {
 
 			Ω(rr.Code).Should(Equal(http.StatusBadRequest))
 
-			files, err := ioutil.ReadDir(filepath.Join(s.ReportPath, executionID))
+			files, err := ioutil.ReadDir(filepath.Join(cfg.ReportDirectory, executionID))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveLen(0))
+		})
+		It("fails with the problems if the results are invalid", func() {
+			mockLog.EXPECT().Error(gm.Any(), "results is invalid")
+
+			req, err := http.NewRequest("POST", path, strings.NewReader(
+				`{ "test": [{ "value": 1.0, "labels": { "label_a": "AAA", "label_c": "CCC" }}], "other": [] }`))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			router.ServeHTTP(rr, req)
+
+			Ω(rr.Code).Should(Equal(http.StatusBadRequest))
+			Ω(rr.Header().Get("Content-Type")).Should(Equal("application/json"))
+			Ω(rr.Body.String()).Should(MatchJSON(`{"problems": [
+				{"metric": "other", "message": "is not defined"},
+				{"metric": "test", "index": 0, "message": "label \"label_b\" is missing"},
+				{"metric": "test", "index": 0, "message": "label \"label_c\" is not defined"}
+			]}`))
+
+			files, err := ioutil.ReadDir(filepath.Join(cfg.ReportDirectory, executionID))
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(files).Should(HaveLen(0))
 		})
+		It("accepts invalid results if the metrics are lenient", func() {
+			cfg.Metrics.Lenient = true
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("name", gm.Any(), "path", gm.Any()).Return(mockLog)
+			mockLog.EXPECT().Info("received report")
+
+			req, err := http.NewRequest("POST", path, strings.NewReader(`{ "other": [{ "value": 1.0, "labels": { "label_c": "CCC" }}] }`))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			router.ServeHTTP(rr, req)
+
+			Ω(rr.Code).Should(Equal(http.StatusOK))
+		})
+		It("fails if job is unknown", func() {
+			mockLog.EXPECT().Error(gm.Any(), gm.Any())
+
+			cfg.Name = "other"
+			req, err := http.NewRequest("POST", path, strings.NewReader(reportJSON))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			router.ServeHTTP(rr, req)
+
+			Ω(rr.Code).Should(Equal(http.StatusNotFound))
+		})
 	})
 
 	Context("middleware", func() {
@@ -128,13 +178,13 @@ var _ = Describe("HTTP", func() {
 			handler *testing.FakeHandler
 		)
 		BeforeEach(func() {
-			handler = &testing.FakeHandler{}
+			handler = &testing.FakeHandler{StatusCode: http.StatusOK}
 			h := s.middleware(handler)
 			router.HandleFunc(CallbackBasePath+CallbackBaseResultSubPath, h.ServeHTTP)
 		})
 
 		It("should allow the request", func() {
-			mockCache.EXPECT().Has(node, executionID).Return(true)
+			mockCache.EXPECT().Has(jobName, node, executionID).Return(true)
 
 			req, err := http.NewRequest("POST", path, strings.NewReader(""))
 			Ω(err).ShouldNot(HaveOccurred())
@@ -144,7 +194,7 @@ var _ = Describe("HTTP", func() {
 			handler.ValidateRequestCount(GinkgoT(), 1)
 		})
 		It("should allow the request if cache is nil", func() {
-			mockCache.EXPECT().Has(node, executionID).Return(true)
+			mockCache.EXPECT().Has(jobName, node, executionID).Return(true)
 			s.InjectCache(nil)
 			req, err := http.NewRequest("POST", path, strings.NewReader(""))
 			Ω(err).ShouldNot(HaveOccurred())
@@ -154,7 +204,7 @@ var _ = Describe("HTTP", func() {
 			handler.ValidateRequestCount(GinkgoT(), 1)
 		})
 		It("should deny if execution is not known", func() {
-			mockCache.EXPECT().Has(node, executionID).Return(false)
+			mockCache.EXPECT().Has(jobName, node, executionID).Return(false)
 
 			req, err := http.NewRequest("POST", path, strings.NewReader(""))
 			Ω(err).ShouldNot(HaveOccurred())
@@ -175,19 +225,19 @@ var _ = Describe("HTTP", func() {
 		)
 		BeforeEach(func() {
 			fileName = uuid.New().String() + ".txt"
-			path = fmt.Sprintf("/report/%s/%s%s", node, executionID, CallbackBaseFileSubPath)
+			path = fmt.Sprintf("/report/%s/%s/%s%s", jobName, node, executionID, CallbackBaseFileSubPath)
 			router.HandleFunc(CallbackBasePath+CallbackBaseFileSubPath, s.postFile)
 
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "name", gm.Any(), "path", gm.Any(), "length", gm.Any()).Return(mockLog)
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "name", gm.Any(), "path", gm.Any(), "length", gm.Any()).Return(mockLog)
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
 			mockLog.EXPECT().WithValues("name", gm.Any(), "path", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Info("received file")
 		})
 		AfterEach(func() {
 			Ω(rr.Code).Should(Equal(http.StatusOK))
 
-			files, err := ioutil.ReadDir(filepath.Join(s.ReportPath, executionID))
+			files, err := ioutil.ReadDir(filepath.Join(cfg.ReportDirectory, executionID))
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(files).Should(HaveLen(1))
 			if generatedFileExtension != "" {
@@ -197,7 +247,7 @@ var _ = Describe("HTTP", func() {
 				Ω(files[0].Name()).Should(Equal(node + "-" + fileName))
 			}
 
-			b, err := ioutil.ReadFile(filepath.Join(s.ReportPath, executionID, files[0].Name()))
+			b, err := ioutil.ReadFile(filepath.Join(cfg.ReportDirectory, executionID, files[0].Name()))
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(b).Should(Equal([]byte("foo")))
 		})
@@ -242,15 +292,15 @@ var _ = Describe("HTTP", func() {
 		BeforeEach(func() {
 			mockRecord = mock_record.NewMockEventRecorder(mockCtrl)
 			s.InjectEventRecorder(mockRecord)
-			path = fmt.Sprintf("/report/%s/%s%s", node, executionID, CallbackBaseEventSubPath)
+			path = fmt.Sprintf("/report/%s/%s/%s%s", jobName, node, executionID, CallbackBaseEventSubPath)
 			router.HandleFunc(CallbackBasePath+CallbackBaseEventSubPath, s.postEvent)
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
 		})
 		It("succeed if event with message is sent", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 			mockRecord.EXPECT().Event(gm.Any(), "Warning", "TestReason", "test message")
 			mockLog.EXPECT().Info("event created")
 			mockReader.EXPECT().
@@ -265,8 +315,8 @@ var _ = Describe("HTTP", func() {
 		})
 		It("succeed if event with message with args is sent", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 			mockRecord.EXPECT().Eventf(gm.Any(), "Warning", "TestReason", "test message: %s", "a1")
 			mockLog.EXPECT().Info("event created")
 			mockReader.EXPECT().
@@ -282,8 +332,8 @@ var _ = Describe("HTTP", func() {
 
 		It("fails if json is invalid", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().WithValues("result", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Error(gm.Any(), gm.Any())
 
@@ -298,8 +348,8 @@ var _ = Describe("HTTP", func() {
 
 		It("fails if event is invalid", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().WithValues("result", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Error(gm.Any(), gm.Any())
 
@@ -314,8 +364,8 @@ var _ = Describe("HTTP", func() {
 
 		It("fails if pod not found", func() {
 
-			mockCache.EXPECT().ReportReceived(executionID, node, gm.Any(), gm.Any())
-			mockLog.EXPECT().WithValues("node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
+			mockCache.EXPECT().ReportReceived(jobName, executionID, node, gm.Any(), gm.Any())
+			mockLog.EXPECT().WithValues("job", jobName, "node", node, "id", executionID, "length", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().WithValues("result", gm.Any()).Return(mockLog)
 			mockLog.EXPECT().Error(gm.Any(), gm.Any())
 			mockReader.EXPECT().
@@ -344,10 +394,10 @@ var _ = Describe("HTTP", func() {
 
 	Context("GenericAPIServer", func() {
 		BeforeEach(func() {
-			mockLog.EXPECT().Info(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any())
+			mockLog.EXPECT().Info(gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any(), gm.Any()).Times(2)
 		})
 		It("returns a server", func() {
-			sfs := GenericAPIServer(1234, "")
+			sfs := GenericAPIServer(1234)
 			Ω(sfs).ShouldNot(BeNil())
 			Ω(sfs.(*PostServer).Port).Should(Equal(1234))
 			Ω(sfs.(*PostServer).Kind).Should(Equal("internal"))
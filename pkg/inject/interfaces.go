@@ -24,7 +24,17 @@ type Reader interface {
 	InjectReader(client.Reader)
 }
 
+// Scheduler inject the scheduler
+type Scheduler interface {
+	InjectScheduler(lifecycle.Scheduler)
+}
+
 // Cache inject an event recorder
 type Config interface {
 	InjectConfig(*config.Config)
 }
+
+// CompletionHook is notified once an execution is completed
+type CompletionHook interface {
+	lifecycle.CompletionHook
+}
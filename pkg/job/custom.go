@@ -7,6 +7,14 @@ import (
 
 // CustomPodEnv interface
 type CustomPodEnv interface {
-	// ExtendEnv extend the env for the job pod
+	// ExtendEnv extend the env for the job pod, nodeName is the name of the target the pod is started for
 	ExtendEnv(cfg *config.Config, nodeName string, id string, serviceIP string, containers corev1.Container) []corev1.EnvVar
 }
+
+// CustomPodMutator interface
+type CustomPodMutator interface {
+	// MutatePod modify the job pod after the controller has applied its fields, e.g. to add volumes, tolerations or sidecars.
+	// The fields of the controller (name, labels, target annotation, node name, restart policy and env variables)
+	// are applied again after all mutators. An error prevents the pod from being created
+	MutatePod(cfg *config.Config, target Target, id string, pod *corev1.Pod) error
+}
@@ -3,7 +3,7 @@ package job
 import (
 	"bytes"
 	"fmt"
-	"text/template"
+	"strconv"
 
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/controller"
@@ -19,7 +19,10 @@ import (
 
 const (
 	envNodeName                 = "NODE_NAME"
+	envTargetName               = "TARGET_NAME"
+	envTargetKind               = "TARGET_KIND"
 	envExecutionId              = "EXECUTION_ID"
+	envAttempt                  = "ATTEMPT"
 	envNamespace                = "NAMESPACE"
 	envCallbackServiceName      = "CALLBACK_SERVICE_NAME"
 	envCallbackServicePort      = "CALLBACK_SERVICE_PORT"
@@ -31,7 +34,10 @@ const (
 var (
 	reservedEnvVars = map[string]bool{
 		envNodeName:            true,
+		envTargetName:          true,
+		envTargetKind:          true,
 		envExecutionId:         true,
+		envAttempt:             true,
 		envNamespace:           true,
 		envCallbackServiceName: true,
 		envCallbackServicePort: true,
@@ -49,17 +55,28 @@ func MatchingLabels(name string) client.MatchingLabels {
 	return client.MatchingLabels{controller.LabelOwner: name}
 }
 
-// New create a new job
-func New(cfg *config.Config, nodeName, id, serviceIP string, owner runtime.Object, extender ...CustomPodEnv) (*corev1.Pod, error) {
+// New create a new job pod of the target for the given attempt
+func New(cfg *config.Config, target Target, id string, attempt int, serviceIP string, owner runtime.Object, mutators []CustomPodMutator, extender ...CustomPodEnv) (*corev1.Pod, error) {
 
-	podName := cfg.PodName(nodeName, id)
+	podName := cfg.PodName(target.Name, id)
 
-	data := map[string]string{
+	data := map[string]interface{}{
 		"Namespace":   cfg.Namespace,
 		"ExecutionID": id,
-		"NodeName":    nodeName,
+		"Attempt":     attempt,
+		"NodeName":    target.NodeName,
+		"Node":        target.Node,
+		"Target":      target,
+		"Custom":      cfg.Custom,
+		"Callback": map[string]string{
+			"ServiceName": serviceIP,
+			"ServicePort": fmt.Sprintf("%d", cfg.CallbackServicePort),
+			"ResultURL":   callbackURL(cfg, target, id, serviceIP, http.CallbackBaseResultSubPath),
+			"FileURL":     callbackURL(cfg, target, id, serviceIP, http.CallbackBaseFileSubPath),
+			"EventURL":    callbackURL(cfg, target, id, serviceIP, http.CallbackBaseEventSubPath),
+		},
 	}
-	tmpl, err := template.New("job-pod").Parse(cfg.JobPodTemplate)
+	tmpl, err := cfg.ParsePodTemplate()
 	if err != nil {
 		return nil, err
 	}
@@ -82,15 +99,57 @@ func New(cfg *config.Config, nodeName, id, serviceIP string, owner runtime.Objec
 		return nil, err
 	}
 
+	// add the env of the extenders
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = extendEnv(cfg, target, id, serviceIP, pod.Spec.Containers[i], extender)
+	}
+	for i := range pod.Spec.InitContainers {
+		pod.Spec.InitContainers[i].Env = extendEnv(cfg, target, id, serviceIP, pod.Spec.InitContainers[i], extender)
+	}
+
+	env := reservedEnv(cfg, target, id, attempt, serviceIP)
+	applyReserved(cfg, target, podName, id, attempt, env, pod)
+
+	if owner != nil {
+		if mo, ok := owner.(metav1.Object); ok {
+			_ = controllerutil.SetOwnerReference(mo, pod, scheme)
+		}
+	}
+
+	for _, m := range mutators {
+		if err := m.MutatePod(cfg, target, id, pod); err != nil {
+			return nil, fmt.Errorf("custom pod mutator %T failed: %v", m, err)
+		}
+	}
+	if len(mutators) > 0 {
+		// the pod is detached from the cache if a mutator changes the fields of the controller
+		applyReserved(cfg, target, podName, id, attempt, env, pod)
+	}
+
+	return pod, nil
+}
+
+// applyReserved set the fields of the pod the controller relies on
+func applyReserved(cfg *config.Config, target Target, podName string, id string, attempt int, env []corev1.EnvVar, pod *corev1.Pod) {
 	pod.ObjectMeta.Name = podName
 	pod.ObjectMeta.Namespace = cfg.Namespace
 
 	// assure correct labels
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
 	pod.Labels[controller.LabelExecutionID] = id
 	pod.Labels[controller.LabelOwner] = cfg.Name
+	pod.Labels[controller.LabelAttempt] = strconv.Itoa(attempt)
+	pod.Annotations[controller.AnnotationTarget] = target.Name
 
-	// assure correct node name
-	pod.Spec.NodeName = nodeName
+	// assure correct node name, pods of targets without node are scheduled by kubernetes
+	if target.NodeName != "" {
+		pod.Spec.NodeName = target.NodeName
+	}
 
 	// assure correct service account
 	pod.Spec.ServiceAccountName = cfg.JobServiceAccount
@@ -100,47 +159,53 @@ func New(cfg *config.Config, nodeName, id, serviceIP string, owner runtime.Objec
 
 	// assure correct env
 	for i := range pod.Spec.Containers {
-		newEnv := mergeEnv(cfg, nodeName, id, serviceIP, pod.Spec.Containers[i], extender)
-		pod.Spec.Containers[i].Env = newEnv
+		pod.Spec.Containers[i].Env = withReservedEnv(pod.Spec.Containers[i].Env, env)
 	}
 	for i := range pod.Spec.InitContainers {
-		newEnv := mergeEnv(cfg, nodeName, id, serviceIP, pod.Spec.InitContainers[i], extender)
-		pod.Spec.InitContainers[i].Env = newEnv
+		pod.Spec.InitContainers[i].Env = withReservedEnv(pod.Spec.InitContainers[i].Env, env)
 	}
+}
 
-	if owner != nil {
-		if mo, ok := owner.(metav1.Object); ok {
-			_ = controllerutil.SetOwnerReference(mo, pod, scheme)
-		}
+// extendEnv get the env of the container with the env of the extenders
+func extendEnv(cfg *config.Config, target Target, id string, serviceIP string, container corev1.Container, extender []CustomPodEnv) []corev1.EnvVar {
+	newEnv := append([]corev1.EnvVar{}, container.Env...)
+	for _, e := range extender {
+		newEnv = append(newEnv, e.ExtendEnv(cfg, target.Name, id, serviceIP, container)...)
 	}
-
-	return pod, err
+	return newEnv
 }
 
-func mergeEnv(cfg *config.Config, nodeName string, id string, serviceIP string, container corev1.Container, extender []CustomPodEnv) []corev1.EnvVar {
+// withReservedEnv replace the reserved env variables with the ones of the controller
+func withReservedEnv(env []corev1.EnvVar, reserved []corev1.EnvVar) []corev1.EnvVar {
 	var newEnv []corev1.EnvVar
-	for _, e := range container.Env {
+	for _, e := range env {
 		// keep all non reserved env variables
 		if _, ok := reservedEnvVars[e.Name]; !ok {
 			newEnv = append(newEnv, e)
 		}
 	}
+	return append(newEnv, reserved...)
+}
 
-	for _, e := range extender {
-		newEnv = append(newEnv, e.ExtendEnv(cfg, nodeName, id, serviceIP, container)...)
-	}
-
+// reservedEnv get the env variables the controller provides to each container
+func reservedEnv(cfg *config.Config, target Target, id string, attempt int, serviceIP string) []corev1.EnvVar {
+	var newEnv []corev1.EnvVar
 	newEnv = append(newEnv, corev1.EnvVar{Name: envExecutionId, Value: id})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envAttempt, Value: strconv.Itoa(attempt)})
 	newEnv = append(newEnv, corev1.EnvVar{Name: envNamespace, Value: cfg.Namespace})
-	newEnv = append(newEnv, corev1.EnvVar{Name: envNodeName, Value: nodeName})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envNodeName, Value: target.NodeName})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envTargetName, Value: target.Name})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envTargetKind, Value: target.Kind})
 	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceName, Value: serviceIP})
 	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServicePort, Value: fmt.Sprintf("%d", cfg.CallbackServicePort)})
-	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceResultURL,
-		Value: fmt.Sprintf("http://%s:%d/report/%s/%s%s", serviceIP, cfg.CallbackServicePort, nodeName, id, http.CallbackBaseResultSubPath)})
-	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceFileURL,
-		Value: fmt.Sprintf("http://%s:%d/report/%s/%s%s", serviceIP, cfg.CallbackServicePort, nodeName, id, http.CallbackBaseFileSubPath)})
-	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceEventURL,
-		Value: fmt.Sprintf("http://%s:%d/report/%s/%s%s", serviceIP, cfg.CallbackServicePort, nodeName, id, http.CallbackBaseEventSubPath)})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceResultURL, Value: callbackURL(cfg, target, id, serviceIP, http.CallbackBaseResultSubPath)})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceFileURL, Value: callbackURL(cfg, target, id, serviceIP, http.CallbackBaseFileSubPath)})
+	newEnv = append(newEnv, corev1.EnvVar{Name: envCallbackServiceEventURL, Value: callbackURL(cfg, target, id, serviceIP, http.CallbackBaseEventSubPath)})
 
 	return newEnv
 }
+
+// callbackURL get the url of a callback api for the pod of the target
+func callbackURL(cfg *config.Config, target Target, id string, serviceIP string, subPath string) string {
+	return fmt.Sprintf("http://%s:%d/report/%s/%s/%s%s", serviceIP, cfg.CallbackServicePort, cfg.Name, target.Name, id, subPath)
+}
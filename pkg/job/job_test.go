@@ -1,6 +1,8 @@
 package job
 
 import (
+	"fmt"
+
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/controller"
 	"github.com/ghodss/yaml"
@@ -9,6 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/types"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
 )
@@ -20,6 +23,7 @@ var _ = Describe("Job", func() {
 			name      string
 			namespace string
 			nodeName  string
+			target    Target
 			id        string
 			serviceIP string
 			sacc      string
@@ -36,11 +40,12 @@ var _ = Describe("Job", func() {
 				CallbackServicePort: 12345,
 			}
 			nodeName = uuid.New().String()
+			target = NodeTarget(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}})
 			id = uuid.New().String()
 			serviceIP = "1.1.1.1"
 		})
 		It("should set default fields", func() {
-			pod, err := New(cfg, nodeName, id, serviceIP, nil)
+			pod, err := New(cfg, target, id, 1, serviceIP, nil, nil)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(pod).ShouldNot(BeNil())
 
@@ -52,6 +57,75 @@ var _ = Describe("Job", func() {
 
 			Ω(pod.Labels[controller.LabelExecutionID]).Should(Equal(id))
 			Ω(pod.Labels[controller.LabelOwner]).Should(Equal(name))
+			Ω(pod.Labels[controller.LabelAttempt]).Should(Equal("1"))
+			Ω(pod.Annotations[controller.AnnotationTarget]).Should(Equal(nodeName))
+		})
+
+		It("should not bind the pod of a target without node", func() {
+			target = Target{Name: "ns-a", Kind: config.TargetKindNamespace}
+			pod, err := New(cfg, target, id, 1, serviceIP, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(pod.Name).Should(Equal(name + "-job-ns-a-" + id))
+			Ω(pod.Spec.NodeName).Should(BeEmpty())
+			Ω(pod.Annotations[controller.AnnotationTarget]).Should(Equal("ns-a"))
+			Ω(controller.Target(pod)).Should(Equal("ns-a"))
+		})
+
+		It("should render the target in the pod template", func() {
+			cfg.JobPodTemplate = "kind: Pod\nmetadata:\n  labels:\n    pv: '{{ .Target.Name }}'\n    class: '{{ index .Target.Data \"storageClass\" }}'"
+			target = Target{Name: "pv-a", Kind: config.TargetKindPersistentVolume, Data: map[string]string{"storageClass": "fast"}}
+			pod, err := New(cfg, target, id, 1, serviceIP, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(pod.Labels["pv"]).Should(Equal("pv-a"))
+			Ω(pod.Labels["class"]).Should(Equal("fast"))
+		})
+
+		It("should render the template data with the template functions", func() {
+			node := corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        nodeName,
+					Labels:      map[string]string{"zone": "a"},
+					Annotations: map[string]string{"owner": "team-a"},
+				},
+				Status: corev1.NodeStatus{
+					Capacity:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+					Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+				},
+			}
+			target = NodeTarget(node)
+			cfg.Custom = map[string]interface{}{"image": "busybox"}
+			cfg.JobPodTemplate = `
+kind: Pod
+metadata:
+  annotations:
+    zone: {{ .Node.Labels.zone | quote }}
+    owner: {{ index .Node.Annotations "owner" | b64enc }}
+    cpu: {{ .Node.Capacity.cpu | quote }}
+    ip: {{ .Node.Addresses.InternalIP }}
+    attempt: {{ .Attempt | quote }}
+    result: {{ .Callback.ResultURL }}
+    missing: {{ .Custom.missing | default "fallback" }}
+spec:
+  containers:
+    - name: job
+      image: {{ .Custom.image }}
+      resources:
+        {{- toYaml (dict "limits" (dict "cpu" "1")) | nindent 8 }}
+`
+			pod, err := New(cfg, target, id, 2, serviceIP, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(pod.Annotations["zone"]).Should(Equal("a"))
+			Ω(pod.Annotations["owner"]).Should(Equal("dGVhbS1h"))
+			Ω(pod.Annotations["cpu"]).Should(Equal("4"))
+			Ω(pod.Annotations["ip"]).Should(Equal("10.0.0.1"))
+			Ω(pod.Annotations["attempt"]).Should(Equal("2"))
+			Ω(pod.Annotations["result"]).Should(Equal("http://1.1.1.1:12345/report/" + name + "/" + nodeName + "/" + id + "/result"))
+			Ω(pod.Annotations["missing"]).Should(Equal("fallback"))
+			Ω(pod.Spec.Containers[0].Image).Should(Equal("busybox"))
+			Ω(pod.Spec.Containers[0].Resources.Limits.Cpu().String()).Should(Equal("1"))
 		})
 
 		Context("Env vars", func() {
@@ -82,16 +156,19 @@ var _ = Describe("Job", func() {
 				cfg.JobPodTemplate = string(b)
 			})
 			It("should set default env vars", func() {
-				pod, _ := New(cfg, nodeName, id, serviceIP, nil)
+				pod, _ := New(cfg, target, id, 1, serviceIP, nil, nil)
 
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envExecutionId, id))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envAttempt, "1"))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envNamespace, namespace))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envNodeName, nodeName))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envTargetName, nodeName))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envTargetKind, config.TargetKindNode))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceName, serviceIP))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServicePort, "12345"))
-				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceResultURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/result"))
-				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceFileURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/file"))
-				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceEventURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/event"))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceResultURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/result"))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceFileURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/file"))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envCallbackServiceEventURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/event"))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar("FOO", "bar"))
 
 				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envExecutionId, id))
@@ -99,29 +176,61 @@ var _ = Describe("Job", func() {
 				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envNodeName, nodeName))
 				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceName, serviceIP))
 				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServicePort, "12345"))
-				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceResultURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/result"))
-				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceFileURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/file"))
-				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceEventURL, "http://1.1.1.1:12345/report/"+nodeName+"/"+id+"/event"))
+				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceResultURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/result"))
+				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceFileURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/file"))
+				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar(envCallbackServiceEventURL, "http://1.1.1.1:12345/report/"+name+"/"+nodeName+"/"+id+"/event"))
 				Ω(pod.Spec.InitContainers[0].Env).Should(HaveEnvVar("BAR", "foo"))
 			})
 
 			It("should have a correct owner reference", func() {
 				ownerId := uuid.New().String()
 				ownerName := uuid.New().String()
-				pod, _ := New(cfg, nodeName, id, serviceIP, &corev1.Pod{
+				pod, _ := New(cfg, target, id, 1, serviceIP, &corev1.Pod{
 					ObjectMeta: metav1.ObjectMeta{
 						UID:  ktypes.UID(ownerId),
 						Name: ownerName,
 					},
-				})
+				}, nil)
 
 				Ω(pod.OwnerReferences).Should(HaveLen(1))
 				Ω(string(pod.OwnerReferences[0].UID)).Should(Equal(ownerId))
 				Ω(pod.OwnerReferences[0].Name).Should(Equal(ownerName))
 			})
 
+			It("should apply the custom pod mutators after the controller fields", func() {
+				pod, err := New(cfg, target, id, 1, serviceIP, nil, []CustomPodMutator{&customMutator{}})
+				Ω(err).ShouldNot(HaveOccurred())
+
+				Ω(pod.Annotations["mutated"]).Should(Equal(pod.Spec.Containers[0].Env[0].Name))
+				Ω(pod.Spec.Volumes).Should(HaveLen(1))
+				Ω(pod.Spec.Volumes[0].Name).Should(Equal(target.Name))
+			})
+
+			It("should keep the fields of the controller changed by a custom pod mutator", func() {
+				pod, err := New(cfg, target, id, 2, serviceIP, nil, []CustomPodMutator{&overwritingMutator{}})
+				Ω(err).ShouldNot(HaveOccurred())
+
+				Ω(pod.Name).Should(Equal(cfg.PodName(target.Name, id)))
+				Ω(pod.Labels).Should(HaveKeyWithValue(controller.LabelExecutionID, id))
+				Ω(pod.Labels).Should(HaveKeyWithValue(controller.LabelOwner, name))
+				Ω(pod.Labels).Should(HaveKeyWithValue(controller.LabelAttempt, "2"))
+				Ω(pod.Labels).Should(HaveKeyWithValue("custom", "label"))
+				Ω(pod.Annotations).Should(HaveKeyWithValue(controller.AnnotationTarget, nodeName))
+				Ω(pod.Spec.NodeName).Should(Equal(nodeName))
+				Ω(pod.Spec.RestartPolicy).Should(Equal(corev1.RestartPolicyNever))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envExecutionId, id))
+				Ω(pod.Spec.Containers[0].Env).ShouldNot(HaveEnvVar(envExecutionId, "mutated"))
+				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar("FOO", "mutated"))
+			})
+
+			It("should fail if a custom pod mutator fails", func() {
+				_, err := New(cfg, target, id, 1, serviceIP, nil, []CustomPodMutator{&customMutator{err: fmt.Errorf("failed")}})
+				Ω(err).Should(HaveOccurred())
+				Ω(err.Error()).Should(ContainSubstring("failed"))
+			})
+
 			It("should have a correct custom env variables reference", func() {
-				pod, _ := New(cfg, nodeName, id, serviceIP, nil, &customEnv{})
+				pod, _ := New(cfg, target, id, 1, serviceIP, nil, nil, &customEnv{})
 
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar(envNamespace, namespace))
 				Ω(pod.Spec.Containers[0].Env).Should(HaveEnvVar("CUSTOM", "VALUE"))
@@ -136,6 +245,32 @@ func (ce *customEnv) ExtendEnv(cfg *config.Config, nodeName string, id string, s
 	return []corev1.EnvVar{{Name: envNamespace, Value: "notMyNamespace"}, {Name: "CUSTOM", Value: "VALUE"}}
 }
 
+type customMutator struct {
+	err error
+}
+
+func (cm *customMutator) MutatePod(cfg *config.Config, target Target, id string, pod *corev1.Pod) error {
+	if cm.err != nil {
+		return cm.err
+	}
+	pod.Annotations["mutated"] = pod.Spec.Containers[0].Env[0].Name
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: target.Name})
+	return nil
+}
+
+// overwritingMutator changes the fields of the controller
+type overwritingMutator struct{}
+
+func (m *overwritingMutator) MutatePod(cfg *config.Config, target Target, id string, pod *corev1.Pod) error {
+	pod.Name = "mutated"
+	pod.Labels = map[string]string{controller.LabelExecutionID: "mutated", controller.LabelAttempt: "9", "custom": "label"}
+	pod.Annotations = nil
+	pod.Spec.NodeName = "mutated"
+	pod.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: envExecutionId, Value: "mutated"}, {Name: "FOO", Value: "mutated"}}
+	return nil
+}
+
 func HaveEnvVar(name, value string) types.GomegaMatcher {
 	return ContainElement(And(WithTransform(getName, Equal(name)), WithTransform(getValue, Equal(value))))
 }
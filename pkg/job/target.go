@@ -0,0 +1,64 @@
+package job
+
+import (
+	"github.com/bakito/batch-job-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Target a target of a job execution, one pod is started for each target
+type Target struct {
+	// Name the unique name of the target within an execution.
+	// It is used for the pod name, the callback urls and as node label of the metrics
+	Name string
+	// Kind the kind of the target
+	Kind string
+	// NodeName the node to run the pod on, if empty the pod is scheduled by kubernetes
+	NodeName string
+	// Taints the taints of the node of the target that must be tolerated by the pod
+	Taints []corev1.Taint
+	// Labels the labels of the target resource
+	Labels map[string]string
+	// Annotations the annotations of the target resource
+	Annotations map[string]string
+	// Data additional data of the target
+	Data map[string]string
+	// Node the data of the node of node targets
+	Node *Node
+}
+
+// Node the data of a node provided to the pod template
+type Node struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Capacity the capacity of the node by resource name
+	Capacity map[string]string
+	// Addresses the addresses of the node by address type
+	Addresses map[string]string
+}
+
+// NodeTarget get the target of a node
+func NodeTarget(node corev1.Node) Target {
+	n := &Node{
+		Name:        node.Name,
+		Labels:      node.Labels,
+		Annotations: node.Annotations,
+		Capacity:    make(map[string]string),
+		Addresses:   make(map[string]string),
+	}
+	for name, q := range node.Status.Capacity {
+		n.Capacity[string(name)] = q.String()
+	}
+	for _, a := range node.Status.Addresses {
+		n.Addresses[string(a.Type)] = a.Address
+	}
+	return Target{
+		Name:        node.Name,
+		Kind:        config.TargetKindNode,
+		NodeName:    node.Name,
+		Taints:      node.Spec.Taints,
+		Labels:      node.Labels,
+		Annotations: node.Annotations,
+		Node:        n,
+	}
+}
@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewExecutionID create the id of an execution started at the given time with the time layout.
+// If the id is already used, a counter is appended as suffix '-<n>' until the id is unique
+func NewExecutionID(format string, started time.Time, used func(id string) bool) string {
+	base := started.Format(format)
+	id := base
+	for i := 1; used(id); i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	return id
+}
+
+// ParseExecutionID get the start time of an execution from its id created with the time layout.
+// The precision of the time is defined by the layout, a collision suffix is ignored
+func ParseExecutionID(format string, id string) (time.Time, error) {
+	t, err := time.ParseInLocation(format, id, time.Local)
+	if err == nil {
+		return t, nil
+	}
+	if i := strings.LastIndex(id, "-"); i > 0 {
+		if _, cerr := strconv.Atoi(id[i+1:]); cerr == nil {
+			if t, perr := time.ParseInLocation(format, id[:i], time.Local); perr == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid execution id %q: %v", id, err)
+}
+
+// executionIDUsed check if the id is used by a known execution or an existing report directory,
+// the lock of the job cache must be held
+func (j *jobCache) executionIDUsed(baseDir string, id string) bool {
+	if _, ok := j.executions[id]; ok {
+		return true
+	}
+	_, err := os.Lstat(filepath.Join(baseDir, id))
+	return err == nil
+}
@@ -0,0 +1,117 @@
+package lifecycle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("execution id", func() {
+	var (
+		started time.Time
+	)
+	BeforeEach(func() {
+		started = time.Date(2020, time.September, 1, 10, 30, 42, 0, time.Local)
+	})
+	Context("NewExecutionID", func() {
+		It("should format the start time", func() {
+			id := NewExecutionID(config.DefaultExecutionIDFormat, started, func(string) bool { return false })
+			Ω(id).Should(Equal("20200901103042"))
+		})
+		It("should append a suffix to used ids", func() {
+			used := map[string]bool{"20200901103042": true, "20200901103042-1": true}
+			id := NewExecutionID(config.DefaultExecutionIDFormat, started, func(id string) bool { return used[id] })
+			Ω(id).Should(Equal("20200901103042-2"))
+		})
+	})
+
+	Context("ParseExecutionID", func() {
+		It("should parse the start time", func() {
+			t, err := ParseExecutionID(config.DefaultExecutionIDFormat, "20200901103042")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(t).Should(Equal(started))
+		})
+		It("should ignore the collision suffix", func() {
+			t, err := ParseExecutionID(config.DefaultExecutionIDFormat, "20200901103042-3")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(t).Should(Equal(started))
+		})
+		It("should parse layouts with separators", func() {
+			t, err := ParseExecutionID("20060102-150405", "20200901-103042")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(t).Should(Equal(started))
+			t, err = ParseExecutionID("20060102-150405", "20200901-103042-1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(t).Should(Equal(started))
+		})
+		It("should fail with an id of another format", func() {
+			_, err := ParseExecutionID(config.DefaultExecutionIDFormat, "foo-1")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("cache", func() {
+		var (
+			c      *cache
+			repDir string
+		)
+		BeforeEach(func() {
+			var err error
+			repDir, err = ioutil.TempDir("", "lifecycle")
+			Ω(err).ShouldNot(HaveOccurred())
+			cc, err := NewCache(&config.Config{
+				Name:            "job",
+				ReportDirectory: repDir,
+				ReportHistory:   5,
+				PodPoolSize:     1,
+				Metrics: config.Metrics{
+					Prefix: "id",
+				},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should create unique ids within the same second", func() {
+			ids := make(map[string]bool)
+			for i := 0; i < 5; i++ {
+				id, err := c.NewExecution("job")
+				Ω(err).ShouldNot(HaveOccurred())
+				ids[id] = true
+			}
+			Ω(ids).Should(HaveLen(5))
+		})
+		It("should not reuse the id of a pruned execution", func() {
+			c.InjectConfig(&config.Config{Name: "job", ReportDirectory: repDir, ReportHistory: 1, PodPoolSize: 1, Metrics: config.Metrics{Prefix: "id"}})
+			first, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", first)).ShouldNot(HaveOccurred())
+			second, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", second)).ShouldNot(HaveOccurred())
+			_, err = c.Execution("job", first)
+			Ω(err).Should(HaveOccurred())
+
+			third, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(third).ShouldNot(Equal(first))
+			Ω(third).ShouldNot(Equal(second))
+		})
+		It("should not reuse the id of an existing report directory", func() {
+			id := time.Now().Format(config.DefaultExecutionIDFormat)
+			Ω(os.MkdirAll(filepath.Join(repDir, id), 0755)).ShouldNot(HaveOccurred())
+
+			newID, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			// the second may have passed in the meantime
+			Ω(newID).ShouldNot(Equal(id))
+		})
+	})
+})
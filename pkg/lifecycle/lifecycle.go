@@ -1,6 +1,7 @@
 package lifecycle
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -13,7 +14,24 @@ import (
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	podStatusPending        = "Pending"
+	podStatusStarted        = "Started"
+	podStatusReportReceived = "ReportReceived"
+	podStatusTimedOut       = "TimedOut"
+	podStatusCreationFailed = "CreationFailed"
+	podStatusRetrying       = "Retrying"
+	podStatusCancelled      = "Cancelled"
+)
+
+const (
+	// SummaryFileName the name of the summary file written to the report directory of a completed execution
+	SummaryFileName = "summary.json"
 )
 
 var (
@@ -21,40 +39,109 @@ var (
 )
 
 //NewCache get a new cache
-func NewCache(cfg *config.Config, prom *Collector) Cache {
-	return &cache{
+func NewCache(cfg *config.Config) (Cache, error) {
+	c := &cache{
+		jobs:   make(map[string]*jobCache),
+		log:    log.WithName("cache"),
+		config: *cfg,
+	}
+	for _, jc := range cfg.JobConfigs() {
+		j, err := newJobCache(jc, c.log)
+		if err != nil {
+			return nil, err
+		}
+		c.jobs[jc.Name] = j
+	}
+	return c, nil
+}
+
+func newJobCache(cfg *config.Config, l logr.Logger) (*jobCache, error) {
+	prom, err := NewPromCollector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &jobCache{
+		name:          cfg.Name,
 		executions:    make(map[string]*execution),
 		nodes:         make(map[string]bool),
 		prom:          prom,
-		log:           log.WithName("cache"),
+		log:           l.WithValues("job", cfg.Name),
 		reportHistory: cfg.ReportHistory + 1, // 1+ for latest
 		reportDir:     cfg.ReportDirectory,
 		podPoolSize:   cfg.PodPoolSize,
+		jobTimeout:    cfg.JobTimeout.Duration,
 		config:        *cfg,
-	}
+	}, nil
 }
 
 //Cache interface
 type Cache interface {
-	NewExecution() string
-	AllAdded(executionID string) error
+	NewExecution(jobName string) (string, error)
+	AllAdded(jobName string, executionID string) error
 	AddPod(job Job) error
-	PodTerminated(executionID, node string, phase corev1.PodPhase) error
-	ReportReceived(executionID, node string, processingError error, results Results)
+	// PodTerminated the pod of the given attempt was terminated
+	PodTerminated(jobName string, executionID, node string, attempt int, phase corev1.PodPhase) error
+	ReportReceived(jobName string, executionID, node string, processingError error, results Results)
 	Config() config.Config
 	// Has return true if the executionId is known
-	Has(node string, executionId string) bool
+	Has(jobName string, node string, executionId string) bool
+	// AddListener add a listener to be notified on execution status changes
+	AddListener(listener Listener)
+	// AddCompletionHook add a hook to be called once all pods of an execution are terminated
+	AddCompletionHook(hook CompletionHook)
+	// Restore rebuild the state of a job from the report directory and the existing job pods
+	Restore(jobName string, pods []PodState) error
+	// Executions get the summary of all known executions of a job ordered by execution id
+	Executions(jobName string) ([]ExecutionInfo, error)
+	// Execution get the state of an execution including its nodes and files
+	Execution(jobName string, executionID string) (*ExecutionInfo, error)
+	// Node get the state of the pod of a node within an execution
+	Node(jobName string, executionID string, node string) (*NodeInfo, error)
+	// Cancel stop the workers of an execution and mark its pods that are not terminated as cancelled
+	Cancel(jobName string, executionID string) error
+	// ActiveExecutions get the ids of the executions of a job that are still starting or have pods that are not terminated
+	ActiveExecutions(jobName string) ([]string, error)
+	// ExecutionOverlapped record the decision taken for a new execution that overlapped with an active execution
+	ExecutionOverlapped(jobName string, decision string)
+	// LastScheduled get the last time the job was scheduled by the cron, zero if unknown
+	LastScheduled(jobName string) (time.Time, error)
+	// Scheduled persist the time the job was scheduled by the cron
+	Scheduled(jobName string, t time.Time) error
+	// NextSchedule expose the time of the next scheduled execution
+	NextSchedule(jobName string, next time.Time)
+	// NodeRemoved delete the metrics of a node that no longer exists from all jobs with node targets
+	NodeRemoved(node string)
 }
 
 type cache struct {
-	prom          *Collector
-	executions    map[string]*execution
-	nodes         map[string]bool
+	jobs      map[string]*jobCache
+	log       logr.Logger
+	config    config.Config
+	lock      sync.RWMutex
+	listeners []Listener
+	hooks     []CompletionHook
+	recorder  record.EventRecorder
+}
+
+type jobCache struct {
+	name       string
+	prom       *Collector
+	executions map[string]*execution
+	nodes      map[string]bool
+	// lock guards the executions, nodes and issued ids
+	lock sync.RWMutex
+	// issuedIDs the ids issued within the time slot of the last execution id, pruned executions must not pass their id on
+	issuedIDs     map[string]bool
+	issuedIDsSlot string
 	log           logr.Logger
 	reportDir     string
 	reportHistory int
 	podPoolSize   int
+	jobTimeout    time.Duration
 	config        config.Config
+	configLock    sync.RWMutex
+	// scheduleLock serializes the access to the schedule file
+	scheduleLock sync.Mutex
 }
 
 // verify interface is implemented
@@ -62,81 +149,204 @@ var _ Cache = &cache{}
 
 // Config get the config
 func (c *cache) Config() config.Config {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	return c.config
 }
 
+// InjectEventRecorder inject the event recorder used to report timed out pods
+func (c *cache) InjectEventRecorder(er record.EventRecorder) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.recorder = er
+}
+
+func (c *cache) eventRecorder() record.EventRecorder {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.recorder
+}
+
+// InjectConfig apply a changed config
+func (c *cache) InjectConfig(cfg *config.Config) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// unregister the metrics of removed jobs first, a new job might use the same metrics
+	for name, j := range c.jobs {
+		if cfg.JobConfig(name) == nil {
+			metrics.Registry.Unregister(j.prom)
+			c.log.WithValues("job", name).Info("job removed")
+		}
+	}
+
+	jobs := make(map[string]*jobCache)
+	for _, jc := range cfg.JobConfigs() {
+		if j, ok := c.jobs[jc.Name]; ok {
+			j.update(jc)
+			jobs[jc.Name] = j
+			continue
+		}
+		j, err := newJobCache(jc, c.log)
+		if err != nil {
+			c.log.WithValues("job", jc.Name).Error(err, "could not add job")
+			continue
+		}
+		c.log.WithValues("job", jc.Name).Info("job added")
+		jobs[jc.Name] = j
+	}
+	c.jobs = jobs
+	c.config = *cfg
+}
+
+func (j *jobCache) update(cfg *config.Config) {
+	if err := j.prom.Update(cfg); err != nil {
+		j.log.Error(err, "could not update metrics")
+	}
+
+	j.configLock.Lock()
+	defer j.configLock.Unlock()
+	j.reportHistory = cfg.ReportHistory + 1 // 1+ for latest
+	j.reportDir = cfg.ReportDirectory
+	j.podPoolSize = cfg.PodPoolSize
+	j.jobTimeout = cfg.JobTimeout.Duration
+	j.config = *cfg
+}
+
 // NewExecution setup a new execution
-func (c *cache) NewExecution() string {
-	//                             yyyyMMddHHmmss
-	id := time.Now().Format("20060102150400")
+func (c *cache) NewExecution(jobName string) (string, error) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return "", err
+	}
+	return j.newExecution(&executionHandler{cache: c, job: j}), nil
+}
+
+func (j *jobCache) newExecution(handler podHandler) string {
+	j.configLock.RLock()
+	podPoolSize := j.podPoolSize
+	jobTimeout := j.jobTimeout
+	retry := j.config.Retry
+	idFormat := j.config.IDFormat()
+	baseDir := j.reportDir
+	j.configLock.RUnlock()
+
+	// the id is reserved and the execution registered at once
+	j.lock.Lock()
+	started := time.Now()
+	if slot := started.Format(idFormat); slot != j.issuedIDsSlot {
+		j.issuedIDsSlot = slot
+		j.issuedIDs = make(map[string]bool)
+	}
+	id := NewExecutionID(idFormat, started, func(id string) bool {
+		return j.issuedIDs[id] || j.executionIDUsed(baseDir, id)
+	})
+	j.issuedIDs[id] = true
+
 	e := &execution{
-		id:      id,
-		jobChan: make(chan Job, c.podPoolSize),
+		id:        id,
+		started:   started,
+		cancelled: make(chan struct{}),
+		jobChan:   make(chan Job, podPoolSize),
+		timeout:   jobTimeout,
+		retry:     retry,
+		handler:   handler,
 	}
-	c.executions[id] = e
+	j.executions[id] = e
+	j.lock.Unlock()
+	j.prom.executionStarted(e.started)
 
-	for w := 1; w <= c.podPoolSize; w++ {
+	for w := 1; w <= podPoolSize; w++ {
 		go e.worker(w)
 	}
 
-	reportDir := filepath.Join(c.reportDir, id)
+	reportDir := filepath.Join(baseDir, id)
 
 	if _, err := os.Stat(reportDir); os.IsNotExist(err) {
 		err := os.MkdirAll(reportDir, 0755)
 		if err != nil {
-			c.log.WithValues("dir", reportDir).Error(err, "error creating directory")
+			j.log.WithValues("dir", reportDir).Error(err, "error creating directory")
 		}
 	}
 
 	if runtime.GOOS != "windows" {
-		symlink := filepath.Join(c.reportDir, "latest")
+		symlink := filepath.Join(baseDir, "latest")
 		if _, err := os.Lstat(symlink); err == nil {
 			err := os.Remove(symlink)
 			if err != nil {
-				c.log.WithValues("dir", symlink).Error(err, "error deleting latest link")
+				j.log.WithValues("dir", symlink).Error(err, "error deleting latest link")
 			}
 		}
 		err := os.Symlink(reportDir, symlink)
 		if err != nil {
-			c.log.WithValues("dir", symlink).Error(err, "error creating latest link")
+			j.log.WithValues("dir", symlink).Error(err, "error creating latest link")
 		}
 	}
 	return id
 }
 
 //  AllAdded start the processing
-func (c *cache) AllAdded(executionID string) error {
-	e, err := c.forID(executionID)
+func (c *cache) AllAdded(jobName string, executionID string) error {
+	j, e, err := c.forID(jobName, executionID)
 	if err != nil {
 		return err
 	}
 
+	if err := j.allAdded(e); err != nil {
+		return err
+	}
+	c.notify(j, e)
+	return nil
+}
+
+// allAdded prune the report history and close the job channel of the execution,
+// nothing is done for restored executions or if all pods were already added
+func (j *jobCache) allAdded(e *execution) error {
+	e.addLock.Lock()
+	defer e.addLock.Unlock()
+	if e.isAdded() {
+		return nil
+	}
+
 	cnt := e.length()
-	c.prom.pods(cnt)
+	j.prom.pods(cnt)
+
+	j.configLock.RLock()
+	baseDir := j.reportDir
+	reportHistory := j.reportHistory
+	j.configLock.RUnlock()
 
-	files, err := ioutil.ReadDir(c.reportDir)
+	entries, err := ioutil.ReadDir(baseDir)
 	if err != nil {
-		c.log.WithValues("dir ", c.reportDir).Error(err, "could not list report dir files")
+		j.log.WithValues("dir ", baseDir).Error(err, "could not list report dir files")
 		return err
 	}
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].ModTime().Before(files[j].ModTime())
+	var files []os.FileInfo
+	for _, f := range entries {
+		if f.Name() != scheduleFile {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].ModTime().Before(files[b].ModTime())
 	})
 
-	if len(files) > c.reportHistory {
-		pruneCnt := len(files) - c.reportHistory
+	if len(files) > reportHistory {
+		pruneCnt := len(files) - reportHistory
 		for i := 0; i < pruneCnt; i++ {
-			// delete the execution
-			delete(c.executions, files[i].Name())
+			// delete the execution and its metrics
+			j.removeExecution(files[i].Name())
+			j.prom.executionPruned(files[i].Name())
 
-			dir := c.reportDir + "/" + files[i].Name()
-			c.log.WithValues("dir", dir).Info("deleting report directory")
+			dir := baseDir + "/" + files[i].Name()
+			j.log.WithValues("dir", dir).Info("deleting report directory")
 			err = os.RemoveAll(dir)
 			if err != nil {
-				c.log.WithValues("dir", dir).Error(err, "could delete report directory")
+				j.log.WithValues("dir", dir).Error(err, "could delete report directory")
 			}
 		}
 	}
+	e.allAdded()
 	close(e.jobChan)
 	return nil
 }
@@ -145,117 +355,685 @@ func (e *execution) worker(id int) {
 	l := log.WithName("worker").WithValues("workerID", id)
 	l.V(4).Info("initialized")
 	for job := range e.jobChan {
-		l.V(4).Info("process job", "jobID", job.ID(), "nodeName", job.Node())
-		job.Process()
+		for job != nil {
+			job = e.process(l, job)
+		}
+	}
+}
 
-		p, err := e.pod(job.Node())
-		if err != nil {
-			return
+// process run one attempt of the job and return the next attempt if the pod has to be retried
+func (e *execution) process(l logr.Logger, job Job) Job {
+	p, err := e.pod(job.Node())
+	if err != nil || e.isCancelled() {
+		return nil
+	}
+	l.V(4).Info("process job", "jobID", job.ID(), "nodeName", job.Node(), "attempt", job.Attempt())
+	p.start()
+	if err := job.Process(); err != nil {
+		e.handler.creationFailed(e, job, err)
+	} else if e.isCancelled() {
+		// cancelled while the pod was created
+		if err := job.Delete(); err != nil {
+			l.Error(err, "could not delete pod of cancelled execution", "jobID", job.ID(), "nodeName", job.Node())
 		}
-		p.started = time.Now()
-		p.status = "Started"
+		return nil
+	}
 
-		for p.terminated == nil {
-			time.Sleep(time.Second)
+	// wait until the pod is terminated or the timeout is reached
+	if e.timeout > 0 {
+		timer := time.NewTimer(e.timeout)
+		select {
+		case <-p.wait():
+			timer.Stop()
+		case <-timer.C:
+			e.handler.timedOut(e, job)
 		}
-		l.V(4).Info("job terminated", "jobID", job.ID(), "nodeName", job.Node())
+	} else {
+		<-p.wait()
+	}
+	l.V(4).Info("job terminated", "jobID", job.ID(), "nodeName", job.Node(), "attempt", job.Attempt())
+
+	if !p.retrying() {
+		return nil
+	}
+	if err := job.Delete(); err != nil {
+		l.Error(err, "could not delete pod of failed attempt", "jobID", job.ID(), "nodeName", job.Node())
 	}
+	select {
+	case <-time.After(e.retry.Backoff.Duration):
+	case <-e.cancelled:
+		return nil
+	}
+
+	next := job.Retry()
+	if !p.restart(next.Attempt()) {
+		// cancelled in the meantime
+		return nil
+	}
+	e.handler.retrying(e, next)
+	return next
 }
 
 // AddPod add a new pod
 func (c *cache) AddPod(job Job) error {
-	e, err := c.forID(job.ID())
+	j, e, err := c.forID(job.JobName(), job.ID())
 	if err != nil {
 		return err
 	}
-	c.nodes[job.Node()] = true
-	e.Store(job.Node(), &pod{
-		node: job.Node(),
-	})
+	// the job channel must not be closed while adding
+	e.addLock.Lock()
+	defer e.addLock.Unlock()
+	if e.isCancelled() {
+		return fmt.Errorf("execution '%s' of job '%s' is cancelled", job.ID(), job.JobName())
+	}
+	if e.isAdded() {
+		return fmt.Errorf("all pods of execution '%s' of job '%s' are already added", job.ID(), job.JobName())
+	}
+	j.addNode(job.Node())
+	e.Store(job.Node(), newPod(job.Node(), job.Attempt()))
+	j.prom.attempts(job.Node(), job.ID(), job.Attempt())
 	e.jobChan <- job
 	return nil
 }
 
 // PodTerminated pod was terminated
-func (c *cache) PodTerminated(executionID, node string, phase corev1.PodPhase) error {
-	p, err := c.podForID(executionID, node)
+func (c *cache) PodTerminated(jobName string, executionID, node string, attempt int, phase corev1.PodPhase) error {
+	j, e, err := c.forID(jobName, executionID)
+	if err != nil {
+		return err
+	}
+	p, err := e.pod(node)
 	if err != nil {
 		return err
 	}
-	t := time.Now()
-	p.terminated = &t
-	p.status = string(phase)
-	c.prom.duration(node, executionID, float64(t.Sub(p.started).Milliseconds()))
+	if j.podTerminated(e, p, attempt, phase, &e.retry) {
+		c.notify(j, e)
+	}
+	return nil
+}
+
+// podTerminated mark the attempt of the pod as terminated and record its duration and processing error
+// returns false if the pod was already terminated, deleted after the timeout or the attempt belongs to a previous attempt
+func (j *jobCache) podTerminated(e *execution, p *pod, attempt int, phase corev1.PodPhase, retry *config.Retry) bool {
+	node := p.node
+	t, ok := p.terminate(attempt, string(phase), retry)
+	if !ok {
+		return false
+	}
+	j.prom.duration(node, e.id, float64(t.duration.Milliseconds()))
+	j.countResults(e, p)
 
 	// if not successful or not report received report an error
-	if phase != corev1.PodSucceeded || p.reportReceived == nil {
+	if phase != corev1.PodSucceeded || !t.reportReceived {
 
 		msg := "pod was not successful"
-		if p.reportReceived == nil {
+		if !t.reportReceived {
 			msg = "did not receive report"
 		}
-		c.prom.processingError(node, executionID, true)
-		c.log.WithValues("result ", phase, "node", node, "reports", p.reportReceived != nil).Info(msg)
+		if t.retry {
+			msg += ", retrying"
+		} else {
+			j.prom.processingError(node, e.id, true)
+		}
+		j.log.WithValues("result ", phase, "node", node, "reports", t.reportReceived, "attempt", attempt).Info(msg)
 	} else {
-		c.log.WithValues("result ", phase, "node", node).Info("pod successful")
+		j.log.WithValues("result ", phase, "node", node).Info("pod successful")
 	}
+	return true
+}
 
+// countResults count the results of cumulative metrics of the pod once its final attempt is terminated
+func (j *jobCache) countResults(e *execution, p *pod) {
+	j.prom.results(e.id, p.node, p.finalResults())
+}
+
+// timedOut delete the pod of a job that exceeded the job timeout and mark it as timed out
+func (c *cache) timedOut(j *jobCache, e *execution, job Job) {
+	p, err := e.pod(job.Node())
+	if err != nil {
+		return
+	}
+	t, ok := p.terminate(job.Attempt(), podStatusTimedOut, &e.retry)
+	if !ok {
+		// terminated in the meantime
+		return
+	}
+	j.prom.duration(job.Node(), e.id, float64(t.duration.Milliseconds()))
+	j.countResults(e, p)
+	if !t.retry {
+		j.prom.processingError(job.Node(), e.id, true)
+	}
+
+	podLog := j.log.WithValues("node", job.Node(), "id", e.id, "timeout", e.timeout, "attempt", job.Attempt())
+	podLog.Info("pod timed out")
+	if err := job.Delete(); err != nil {
+		podLog.Error(err, "could not delete timed out pod")
+	}
+	if recorder := c.eventRecorder(); recorder != nil && job.Pod() != nil {
+		recorder.Eventf(job.Pod(), corev1.EventTypeWarning, "TimedOut",
+			"pod on node %s of execution %s did not terminate within %v and was deleted", job.Node(), e.id, e.timeout)
+	}
+	c.notify(j, e)
+}
+
+// creationFailed mark the pod of a job that could not be created as failed
+func (c *cache) creationFailed(j *jobCache, e *execution, job Job, err error) {
+	p, perr := e.pod(job.Node())
+	if perr != nil {
+		return
+	}
+	t, ok := p.terminate(job.Attempt(), podStatusCreationFailed, &e.retry)
+	if !ok {
+		return
+	}
+	if !t.retry {
+		j.prom.processingError(job.Node(), e.id, true)
+	}
+	j.log.WithValues("node", job.Node(), "id", e.id, "attempt", job.Attempt(), "retry", t.retry).Error(err, "could not create pod")
+	c.notify(j, e)
+}
+
+// retrying a new attempt of a job is started
+func (c *cache) retrying(j *jobCache, e *execution, job Job) {
+	j.prom.attempts(job.Node(), e.id, job.Attempt())
+	j.log.WithValues("node", job.Node(), "id", e.id, "attempt", job.Attempt()).Info("retrying pod")
+	if recorder := c.eventRecorder(); recorder != nil && job.Pod() != nil {
+		recorder.Eventf(job.Pod(), corev1.EventTypeNormal, "Retrying",
+			"starting attempt %d of the pod on node %s of execution %s", job.Attempt(), job.Node(), e.id)
+	}
+	c.notify(j, e)
+}
+
+// Cancel stop the workers of an execution and mark its pods that are not terminated as cancelled
+func (c *cache) Cancel(jobName string, executionID string) error {
+	j, e, err := c.forID(jobName, executionID)
+	if err != nil {
+		return err
+	}
+	if !e.cancel() {
+		return nil
+	}
+	cancelled := 0
+	e.Map.Range(func(_, value interface{}) bool {
+		p := value.(*pod)
+		if p.cancel() {
+			cancelled++
+			j.countResults(e, p)
+		}
+		return true
+	})
+	j.prom.cancelled(executionID)
+	j.log.WithValues("id", executionID, "pods", cancelled).Info("execution cancelled")
+	c.notify(j, e)
 	return nil
 }
 
-// ReportReceived report was received
-func (c *cache) ReportReceived(executionID, node string, processingError error, results Results) {
-	for k := range results {
-		for _, r := range results[k] {
-			c.prom.metricFor(executionID, node, k, r)
+// ActiveExecutions get the ids of the executions of a job that are still starting or have pods that are not terminated
+func (c *cache) ActiveExecutions(jobName string) ([]string, error) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range j.executionList() {
+		if e.active() {
+			ids = append(ids, e.id)
 		}
 	}
-	c.prom.processingError(node, executionID, processingError != nil)
+	sort.Strings(ids)
+	return ids, nil
+}
 
-	e, err := c.forID(executionID)
+// ExecutionOverlapped record the decision taken for a new execution that overlapped with an active execution
+func (c *cache) ExecutionOverlapped(jobName string, decision string) {
+	j, err := c.job(jobName)
 	if err != nil {
 		return
 	}
+	j.prom.overlapped(decision)
+	if decision == OverlapSkipped {
+		j.prom.executionSkipped()
+	}
+}
+
+// ReportReceived report was received
+func (c *cache) ReportReceived(jobName string, executionID, node string, processingError error, results Results) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return
+	}
+	j.prom.processingError(node, executionID, processingError != nil)
+
+	e, err := j.forID(executionID)
+	if err != nil {
+		// the report can not be related to an attempt
+		j.prom.results(executionID, node, results)
+		return
+	}
 
 	p, err := e.pod(node)
 	if err != nil {
+		j.prom.results(executionID, node, results)
 		return
 	}
 
-	t := time.Now()
-	p.reportReceived = &t
-	p.status = "ReportReceived"
+	// cumulative metrics are counted when the final attempt of the pod is terminated
+	set, cumulative := j.prom.splitResults(results)
+	j.prom.results(executionID, node, set)
+	p.received(time.Now(), cumulative)
+	j.countResults(e, p)
+	c.notify(j, e)
 }
 
-func (c *cache) Has(node string, executionId string) bool {
-	if _, ok := c.nodes[node]; !ok {
+func (c *cache) Has(jobName string, node string, executionId string) bool {
+	j, err := c.job(jobName)
+	if err != nil {
+		return false
+	}
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	if _, ok := j.nodes[node]; !ok {
 		return false
 	}
-	_, ok := c.executions[executionId]
+	_, ok := j.executions[executionId]
 	return ok
 }
 
-func (c *cache) forID(id string) (*execution, error) {
-	e, ok := c.executions[id]
+// NodeRemoved delete the metrics of a node that no longer exists from all jobs with node targets
+func (c *cache) NodeRemoved(node string) {
+	c.lock.RLock()
+	jobs := make([]*jobCache, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		jobs = append(jobs, j)
+	}
+	c.lock.RUnlock()
+
+	for _, j := range jobs {
+		j.configLock.RLock()
+		kind := j.config.TargetKind()
+		j.configLock.RUnlock()
+		if kind != config.TargetKindNode {
+			continue
+		}
+		j.removeNode(node)
+		j.prom.nodeRemoved(node)
+		j.log.WithValues("node", node).Info("removed metrics of deleted node")
+	}
+}
+
+// AddListener add a listener to be notified on execution status changes
+func (c *cache) AddListener(listener Listener) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// AddCompletionHook add a hook to be called once all pods of an execution are terminated
+func (c *cache) AddCompletionHook(hook CompletionHook) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// Restore rebuild the state of a job from the report directory and the existing job pods
+func (c *cache) Restore(jobName string, pods []PodState) error {
+	j, err := c.job(jobName)
+	if err != nil {
+		return err
+	}
+
+	j.configLock.RLock()
+	baseDir := j.reportDir
+	j.configLock.RUnlock()
+
+	// all executions with a report directory are known
+	if files, err := ioutil.ReadDir(baseDir); err == nil {
+		for _, f := range files {
+			if f.IsDir() {
+				j.restoreExecution(f.Name())
+			}
+		}
+	}
+
+	for _, ps := range pods {
+		e := j.restoreExecution(ps.ExecutionID)
+		j.addNode(ps.Node)
+
+		p := newPod(ps.Node, ps.Attempt)
+		j.prom.attempts(ps.Node, ps.ExecutionID, p.attempt)
+		p.started = ps.Started
+		p.status = podStatusStarted
+		e.Store(ps.Node, p)
+
+		// restore the report if it was already received
+		reportFile := filepath.Join(baseDir, ps.ExecutionID, fmt.Sprintf("%s.json", ps.Node))
+		if fi, err := os.Stat(reportFile); err == nil {
+			if results, err := readResults(reportFile); err != nil {
+				j.log.WithValues("file", reportFile).Error(err, "could not restore report")
+			} else {
+				set, cumulative := j.prom.splitResults(results)
+				j.prom.results(ps.ExecutionID, ps.Node, set)
+				j.prom.processingError(ps.Node, ps.ExecutionID, false)
+				p.received(fi.ModTime(), cumulative)
+			}
+		}
+
+		if ps.Phase == corev1.PodSucceeded || ps.Phase == corev1.PodFailed {
+			// the pod terminated while the controller was down, restored pods can not be retried
+			j.podTerminated(e, p, p.attempt, ps.Phase, nil)
+		} else if e.timeout > 0 {
+			go c.awaitRestored(j, e, p, &restoredJob{jobName: jobName, attempt: p.attempt, state: ps})
+		}
+	}
+
+	executions := j.executionList()
+	for _, e := range executions {
+		if e.length() > 0 {
+			c.notify(j, e)
+		} else {
+			// executions without pods were completed before the restart
+			e.completeOnce.Do(func() {})
+		}
+	}
+	j.log.WithValues("executions", len(executions), "pods", len(pods)).Info("cache restored")
+	return nil
+}
+
+// awaitRestored enforce the job timeout of a restored pod, as restored pods are not processed by a worker
+func (c *cache) awaitRestored(j *jobCache, e *execution, p *pod, job Job) {
+	timer := time.NewTimer(e.timeout - time.Since(p.started))
+	defer timer.Stop()
+	select {
+	case <-p.wait():
+	case <-timer.C:
+		c.timedOut(j, e, job)
+	}
+}
+
+func (j *jobCache) restoreExecution(id string) *execution {
+	j.configLock.RLock()
+	idFormat := j.config.IDFormat()
+	jobTimeout := j.jobTimeout
+	j.configLock.RUnlock()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if e, ok := j.executions[id]; ok {
+		return e
+	}
+	e := &execution{
+		id:        id,
+		added:     true,
+		cancelled: make(chan struct{}),
+		timeout:   jobTimeout,
+	}
+	// the start time of ids with another format is unknown
+	if started, err := ParseExecutionID(idFormat, id); err == nil {
+		e.started = started
+	}
+	j.executions[id] = e
+	return e
+}
+
+func readResults(file string) (Results, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	results := Results{}
+	err = json.Unmarshal(b, &results)
+	return results, err
+}
+
+func (c *cache) notify(j *jobCache, e *execution) {
+	c.completed(j, e)
+	c.lock.RLock()
+	listeners := c.listeners
+	c.lock.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+	status := e.status()
+	status.Job = j.name
+	for _, l := range listeners {
+		l.StatusChanged(status)
+	}
+}
+
+// completed record the completion of an execution once all its pods are terminated
+func (c *cache) completed(j *jobCache, e *execution) {
+	if !e.complete() {
+		return
+	}
+	outcome := e.outcome()
+	e.lock.Lock()
+	e.result = outcome
+	e.lock.Unlock()
+	j.prom.executionCompleted(time.Now(), outcome)
+	j.log.WithValues("id", e.id, "outcome", outcome).Info("execution completed")
+
+	summary, err := c.Execution(j.name, e.id)
+	if err != nil {
+		return
+	}
+	if err := j.writeSummary(summary); err != nil {
+		j.log.WithValues("id", e.id).Error(err, "could not write execution summary")
+	}
+	c.lock.RLock()
+	hooks := c.hooks
+	c.lock.RUnlock()
+	for _, h := range hooks {
+		go h.ExecutionCompleted(*summary)
+	}
+}
+
+// writeSummary write the summary of a completed execution to its report directory
+func (j *jobCache) writeSummary(summary *ExecutionInfo) error {
+	j.configLock.RLock()
+	file := filepath.Join(j.reportDir, summary.ExecutionID, SummaryFileName)
+	j.configLock.RUnlock()
+
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+func (c *cache) job(name string) (*jobCache, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	j, ok := c.jobs[name]
+	if !ok {
+		return nil, &ExecutionIDNotFound{Err: fmt.Errorf("job '%s' not found", name)}
+	}
+	return j, nil
+}
+
+func (c *cache) forID(jobName string, id string) (*jobCache, *execution, error) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := j.forID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return j, e, nil
+}
+
+func (j *jobCache) forID(id string) (*execution, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	e, ok := j.executions[id]
 	if !ok {
 		return nil, &ExecutionIDNotFound{Err: fmt.Errorf("execution with id: '%s' not found", id)}
 	}
 	return e, nil
 }
 
-func (c *cache) podForID(id, node string) (*pod, error) {
-	e, err := c.forID(id)
-	if err != nil {
-		return nil, err
+// executionList get a snapshot of the known executions
+func (j *jobCache) executionList() []*execution {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	executions := make([]*execution, 0, len(j.executions))
+	for _, e := range j.executions {
+		executions = append(executions, e)
 	}
+	return executions
+}
 
-	return e.pod(node)
+func (j *jobCache) removeExecution(id string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	delete(j.executions, id)
+}
+
+func (j *jobCache) addNode(node string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.nodes[node] = true
+}
+
+func (j *jobCache) removeNode(node string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	delete(j.nodes, node)
 }
 
 type execution struct {
 	sync.Map
 	id      string
+	started time.Time
+	// added is true when all pods of the execution are added
+	added bool
+	// cancelled is closed when the execution is cancelled
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+	// completeOnce marks the first detection of the completion
+	completeOnce sync.Once
+	// result the outcome of the completed execution
+	result string
+	lock   sync.RWMutex
+	// addLock serializes adding pods with closing the job channel
+	addLock sync.Mutex
 	jobChan chan Job
+	timeout time.Duration
+	retry   config.Retry
+	handler podHandler
+}
+
+// podHandler handles the pod state changes detected by the workers of an execution
+type podHandler interface {
+	// timedOut the pod did not terminate within the job timeout
+	timedOut(e *execution, job Job)
+	// creationFailed the pod could not be created
+	creationFailed(e *execution, job Job, err error)
+	// retrying a new attempt of the job is started
+	retrying(e *execution, job Job)
+}
+
+type executionHandler struct {
+	cache *cache
+	job   *jobCache
+}
+
+func (h *executionHandler) timedOut(e *execution, job Job) {
+	h.cache.timedOut(h.job, e, job)
+}
+
+func (h *executionHandler) creationFailed(e *execution, job Job, err error) {
+	h.cache.creationFailed(h.job, e, job, err)
+}
+
+func (h *executionHandler) retrying(e *execution, job Job) {
+	h.cache.retrying(h.job, e, job)
+}
+
+func (e *execution) allAdded() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.added = true
+}
+
+func (e *execution) isAdded() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.added
+}
+
+// cancel close the cancelled channel, returns false if the execution was already cancelled
+func (e *execution) cancel() bool {
+	ok := false
+	e.cancelOnce.Do(func() {
+		close(e.cancelled)
+		ok = true
+	})
+	return ok
+}
+
+func (e *execution) isCancelled() bool {
+	select {
+	case <-e.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// active check if the execution is still starting or has pods that are not terminated
+func (e *execution) active() bool {
+	if e.isCancelled() {
+		return false
+	}
+	return !e.terminated()
+}
+
+// terminated check if all pods of the execution are added and terminated
+func (e *execution) terminated() bool {
+	if !e.isAdded() {
+		return false
+	}
+
+	terminated := true
+	e.Map.Range(func(_, value interface{}) bool {
+		p := value.(*pod)
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		terminated = p.terminated != nil
+		return terminated
+	})
+	return terminated
+}
+
+// complete check if the execution is terminated, returns true only for the first call after the termination
+func (e *execution) complete() bool {
+	if !e.terminated() {
+		return false
+	}
+	first := false
+	e.completeOnce.Do(func() {
+		first = true
+	})
+	return first
+}
+
+// outcome get the outcome of a terminated execution
+func (e *execution) outcome() string {
+	if e.isCancelled() {
+		return outcomeCancelled
+	}
+	outcome := outcomeSucceeded
+	e.Map.Range(func(_, value interface{}) bool {
+		p := value.(*pod)
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		if p.status != string(corev1.PodSucceeded) || p.reportReceived == nil {
+			outcome = outcomePartiallyFailed
+		}
+		return outcome == outcomeSucceeded
+	})
+	return outcome
 }
 
 func (e *execution) length() float64 {
@@ -269,6 +1047,30 @@ func (e *execution) length() float64 {
 	return length
 }
 
+func (e *execution) status() ExecutionStatus {
+	status := ExecutionStatus{
+		ExecutionID: e.id,
+	}
+	e.Map.Range(func(_, value interface{}) bool {
+		p := value.(*pod)
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		status.PodsStarted++
+		if p.terminated != nil {
+			if p.status == string(corev1.PodSucceeded) {
+				status.PodsSucceeded++
+			} else {
+				status.PodsFailed++
+			}
+		}
+		if p.reportReceived != nil && (status.LastReportTime == nil || p.reportReceived.After(*status.LastReportTime)) {
+			status.LastReportTime = p.reportReceived
+		}
+		return true
+	})
+	return status
+}
+
 func (e *execution) pod(node string) (*pod, error) {
 	p, ok := e.Load(node)
 	if !ok {
@@ -279,15 +1081,206 @@ func (e *execution) pod(node string) (*pod, error) {
 
 type pod struct {
 	node           string
+	attempt        int
+	retry          bool
 	started        time.Time
 	terminated     *time.Time
 	reportReceived *time.Time
 	status         string
+	// results the results of cumulative metrics of the current attempt, counted once the pod is terminated
+	results Results
+	counted bool
+	// done is closed when the pod is terminated
+	done chan struct{}
+	lock sync.RWMutex
+}
+
+func newPod(node string, attempt int) *pod {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return &pod{
+		node:    node,
+		attempt: attempt,
+		status:  podStatusPending,
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *pod) start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.started = time.Now()
+	if p.terminated == nil {
+		p.status = podStatusStarted
+	}
+}
+
+// termination the result of a pod termination
+type termination struct {
+	duration       time.Duration
+	reportReceived bool
+	retry          bool
+}
+
+// terminate mark the given attempt of the pod as terminated with the given status and wake up the waiting worker
+// if the retry policy allows another attempt, the pod is marked for a retry instead.
+// returns false if the attempt was already terminated or is not the current attempt
+func (p *pod) terminate(attempt int, status string, retry *config.Retry) (termination, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.terminated != nil || p.retry || p.attempt != attempt {
+		return termination{}, false
+	}
+	now := time.Now()
+	t := termination{
+		duration:       now.Sub(p.started),
+		reportReceived: p.reportReceived != nil,
+	}
+	t.retry = retry != nil && retry.ShouldRetry(attempt, status != string(corev1.PodSucceeded), t.reportReceived)
+	if t.retry {
+		p.retry = true
+		p.status = podStatusRetrying
+	} else {
+		p.terminated = &now
+		p.status = status
+	}
+	close(p.done)
+	return t, true
+}
+
+// restart reset the pod waiting for a retry for the given attempt
+// returns false if the pod is not waiting for a retry anymore
+func (p *pod) restart(attempt int) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.retry {
+		return false
+	}
+	p.attempt = attempt
+	p.retry = false
+	p.terminated = nil
+	p.reportReceived = nil
+	p.results = nil
+	p.done = make(chan struct{})
+	return true
+}
+
+// cancel mark the pod as cancelled if it is not terminated yet
+func (p *pod) cancel() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.terminated != nil {
+		return false
+	}
+	if !p.retry {
+		// the done channel of pods waiting for a retry is already closed
+		close(p.done)
+	}
+	now := time.Now()
+	p.terminated = &now
+	p.retry = false
+	p.status = podStatusCancelled
+	return true
+}
+
+// wait get the channel that is closed when the current attempt is terminated
+func (p *pod) wait() <-chan struct{} {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.done
+}
+
+func (p *pod) retrying() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.retry
+}
+
+// received mark the report of the current attempt as received
+// the results of cumulative metrics replace those of a previous report of the same attempt
+func (p *pod) received(t time.Time, results Results) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reportReceived = &t
+	if !p.counted {
+		p.results = results
+	}
+	if p.terminated == nil {
+		p.status = podStatusReportReceived
+	}
+}
+
+// finalResults get the results of cumulative metrics of the final attempt once the pod is terminated
+// the results are returned only once
+func (p *pod) finalResults() Results {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.terminated == nil || p.counted || p.results == nil {
+		return nil
+	}
+	p.counted = true
+	results := p.results
+	p.results = nil
+	return results
 }
 
 // Job interface
 type Job interface {
-	Process()
+	// Process create the pod of the job
+	Process() error
+	// Delete delete the pod of the job
+	Delete() error
+	// Pod get the pod of the job
+	Pod() *corev1.Pod
 	ID() string
 	Node() string
+	JobName() string
+	// Attempt get the attempt of the job starting with 1
+	Attempt() int
+	// Retry get the job for the next attempt on the same node
+	Retry() Job
+}
+
+// restoredJob the job of a restored pod, the pod already exists and can only be deleted
+type restoredJob struct {
+	jobName string
+	attempt int
+	state   PodState
+}
+
+func (j *restoredJob) Process() error {
+	return fmt.Errorf("the pod of the restored execution '%s' of job '%s' can not be created", j.state.ExecutionID, j.jobName)
+}
+
+func (j *restoredJob) Delete() error {
+	if j.state.Delete == nil {
+		return nil
+	}
+	return j.state.Delete()
+}
+
+func (j *restoredJob) Pod() *corev1.Pod {
+	return j.state.Pod
+}
+
+func (j *restoredJob) ID() string {
+	return j.state.ExecutionID
+}
+
+func (j *restoredJob) Node() string {
+	return j.state.Node
+}
+
+func (j *restoredJob) JobName() string {
+	return j.jobName
+}
+
+func (j *restoredJob) Attempt() int {
+	return j.attempt
+}
+
+// Retry restored pods are not retried
+func (j *restoredJob) Retry() Job {
+	return nil
 }
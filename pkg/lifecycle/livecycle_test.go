@@ -1,29 +1,39 @@
 package lifecycle
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var _ = Describe("lifecycle", func() {
 	var (
 		cfg       *config.Config
-		pc        *Collector
 		repDir    string
 		namespace string
 		poolSize  int
 	)
 	BeforeEach(func() {
-		repDir = "test-" + uuid.New().String()
+		var err error
+		repDir, err = ioutil.TempDir("", "lifecycle")
+		Ω(err).ShouldNot(HaveOccurred())
 		namespace = uuid.New().String()
 		poolSize = rand.Int()
 		cfg = &config.Config{
+			Name:            "job",
 			Namespace:       namespace,
 			ReportDirectory: repDir,
 			PodPoolSize:     poolSize,
@@ -31,16 +41,49 @@ var _ = Describe("lifecycle", func() {
 				Prefix: "foo",
 			},
 		}
-		pc, _ = NewPromCollector(cfg)
 	})
 	Context("NewCache", func() {
 		It("should return a new cache", func() {
-			c := NewCache(cfg, pc)
+			c, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
 			Ω(c).ShouldNot(BeNil())
 			Ω(c.(*cache).config).ShouldNot(BeNil())
 			Ω(c.(*cache).log).ShouldNot(BeNil())
-			Ω(c.(*cache).reportDir).Should(Equal(repDir))
-			Ω(c.(*cache).podPoolSize).Should(Equal(poolSize))
+			Ω(c.(*cache).jobs).Should(HaveKey("job"))
+			Ω(c.(*cache).jobs["job"].reportDir).Should(Equal(repDir))
+			Ω(c.(*cache).jobs["job"].podPoolSize).Should(Equal(poolSize))
+		})
+		It("should return a new cache with multiple jobs", func() {
+			cfg.Jobs = []config.Config{
+				{Name: "job-a"},
+				{Name: "job-b", PodPoolSize: 2},
+			}
+			c, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			jobs := c.(*cache).jobs
+			Ω(jobs).Should(HaveLen(2))
+			Ω(jobs["job-a"].reportDir).Should(Equal(filepath.Join(repDir, "job-a")))
+			Ω(jobs["job-a"].podPoolSize).Should(Equal(poolSize))
+			Ω(jobs["job-b"].reportDir).Should(Equal(filepath.Join(repDir, "job-b")))
+			Ω(jobs["job-b"].podPoolSize).Should(Equal(2))
+		})
+	})
+	Context("InjectConfig", func() {
+		It("should keep the metrics of a renamed job", func() {
+			cfg.PodPoolSize = 1
+			cfg.Jobs = []config.Config{{Name: "job-a", Metrics: config.Metrics{Prefix: "rename"}}}
+			c, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			renamed := *cfg
+			renamed.Jobs = []config.Config{{Name: "job-b", Metrics: config.Metrics{Prefix: "rename"}}}
+			c.(*cache).InjectConfig(&renamed)
+			Ω(c.(*cache).jobs).Should(HaveLen(1))
+			Ω(c.(*cache).jobs).Should(HaveKey("job-b"))
+
+			_, err = c.NewExecution("job-b")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(testutil.GatherAndCount(metrics.Registry, "rename_last_execution_start_time_seconds")).Should(Equal(1))
 		})
 	})
 	Context("NewExecution", func() {
@@ -49,30 +92,403 @@ var _ = Describe("lifecycle", func() {
 		)
 		BeforeEach(func() {
 			cfg.PodPoolSize = 0
-			c = NewCache(cfg, pc).(*cache)
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
 		})
 		AfterEach(func() {
-			os.RemoveAll(c.reportDir)
+			os.RemoveAll(repDir)
 		})
 		It("should create an id and directory", func() {
-			id := c.NewExecution()
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
 			Ω(id).ShouldNot(BeEmpty())
-			_, err := os.Stat(filepath.Join(repDir, id))
+			_, err = os.Stat(filepath.Join(repDir, id))
 			Ω(err).ShouldNot(HaveOccurred())
 			_, err = os.Lstat(filepath.Join(repDir, "latest"))
 			Ω(err).ShouldNot(HaveOccurred())
 		})
 		It("should create an id and directory and move the link", func() {
-			id1 := c.NewExecution()
+			id1, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
 			Ω(id1).ShouldNot(BeEmpty())
-			_, err := os.Stat(filepath.Join(repDir, id1))
+			_, err = os.Stat(filepath.Join(repDir, id1))
 			Ω(err).ShouldNot(HaveOccurred())
 			_, err = os.Lstat(filepath.Join(repDir, "latest"))
 			Ω(err).ShouldNot(HaveOccurred())
-			id2 := c.NewExecution()
+			id2, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
 			Ω(id2).ShouldNot(BeEmpty())
 			_, err = os.Lstat(filepath.Join(repDir, id2))
 			Ω(err).ShouldNot(HaveOccurred())
 		})
+		It("should fail for an unknown job", func() {
+			_, err := c.NewExecution("unknown")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+	Context("Restore", func() {
+		var (
+			c *cache
+		)
+		BeforeEach(func() {
+			cfg.PodPoolSize = 1
+			cfg.Metrics.Prefix = "restore"
+			cfg.Metrics.Gauges = map[string]config.Metric{"restore": {Help: "help", Labels: []string{"label"}}}
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+			Ω(os.MkdirAll(filepath.Join(repDir, "old"), 0755)).ShouldNot(HaveOccurred())
+			Ω(os.MkdirAll(filepath.Join(repDir, "running"), 0755)).ShouldNot(HaveOccurred())
+			Ω(ioutil.WriteFile(filepath.Join(repDir, "running", "node-a.json"),
+				[]byte(`{"restore": [{"value": 1.0, "labels": {"label": "a"}}]}`), 0644)).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should restore the executions and pods", func() {
+			started := time.Now().Add(-time.Minute)
+			err := c.Restore("job", []PodState{
+				{ExecutionID: "running", Node: "node-a", Started: started},
+				{ExecutionID: "running", Node: "node-b", Started: started},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			j := c.jobs["job"]
+			Ω(j.executions).Should(HaveKey("old"))
+			Ω(j.executions).Should(HaveKey("running"))
+			Ω(c.Has("job", "node-a", "running")).Should(BeTrue())
+			Ω(c.Has("job", "node-b", "running")).Should(BeTrue())
+
+			pa, err := j.executions["running"].pod("node-a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(pa.started).Should(Equal(started))
+			Ω(pa.reportReceived).ShouldNot(BeNil())
+
+			pb, err := j.executions["running"].pod("node-b")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(pb.reportReceived).Should(BeNil())
+
+			Ω(c.PodTerminated("job", "running", "node-a", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+			Ω(pa.terminated).ShouldNot(BeNil())
+		})
+		It("should complete the execution of pods that terminated before the restore", func() {
+			started := time.Now().Add(-time.Minute)
+			err := c.Restore("job", []PodState{
+				{ExecutionID: "running", Node: "node-a", Started: started, Phase: corev1.PodSucceeded},
+				{ExecutionID: "running", Node: "node-b", Started: started, Phase: corev1.PodFailed},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			active, err := c.ActiveExecutions("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(active).Should(BeEmpty())
+
+			info, err := c.Execution("job", "running")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Finished).ShouldNot(BeNil())
+			Ω(info.Outcome).Should(Equal(outcomePartiallyFailed))
+			Ω(info.PodsByStatus).Should(Equal(map[string]int{string(corev1.PodSucceeded): 1, string(corev1.PodFailed): 1}))
+			_, err = os.Stat(filepath.Join(repDir, "running", SummaryFileName))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		It("should fail for an unknown job", func() {
+			Ω(c.Restore("unknown", nil)).Should(HaveOccurred())
+		})
+	})
+	Context("Scheduled", func() {
+		var (
+			c *cache
+		)
+		BeforeEach(func() {
+			cfg.PodPoolSize = 0
+			cfg.ReportHistory = 1
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should persist the last schedule time", func() {
+			last, err := c.LastScheduled("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(last.IsZero()).Should(BeTrue())
+
+			scheduled := time.Now().Truncate(time.Second)
+			Ω(c.Scheduled("job", scheduled)).ShouldNot(HaveOccurred())
+			last, err = c.LastScheduled("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(last.Equal(scheduled)).Should(BeTrue())
+		})
+		It("should keep the schedule file when pruning old reports", func() {
+			Ω(c.Scheduled("job", time.Now())).ShouldNot(HaveOccurred())
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			_, err = os.Stat(filepath.Join(repDir, scheduleFile))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		It("should fail for an unknown job", func() {
+			_, err := c.LastScheduled("unknown")
+			Ω(err).Should(HaveOccurred())
+			Ω(c.Scheduled("unknown", time.Now())).Should(HaveOccurred())
+		})
+	})
+	Context("JobTimeout", func() {
+		var (
+			c        *cache
+			recorder *record.FakeRecorder
+		)
+		BeforeEach(func() {
+			cfg.PodPoolSize = 1
+			cfg.ReportHistory = 5
+			cfg.JobTimeout = metav1.Duration{Duration: 10 * time.Millisecond}
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+			recorder = record.NewFakeRecorder(10)
+			c.InjectEventRecorder(recorder)
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should delete a pod that exceeds the timeout", func() {
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			job := &testJob{id: id, job: "job", node: "node", deleted: make(chan bool, 1)}
+			Ω(c.AddPod(job)).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+			Eventually(job.deleted, 3*time.Second).Should(Receive())
+			Eventually(recorder.Events).Should(Receive(ContainSubstring("TimedOut")))
+
+			p, err := c.jobs["job"].executions[id].pod("node")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.status).Should(Equal(podStatusTimedOut))
+			Ω(p.terminated).ShouldNot(BeNil())
+
+			// termination of the deleted pod does not change the status
+			Ω(c.PodTerminated("job", id, "node", 1, corev1.PodFailed)).ShouldNot(HaveOccurred())
+			Ω(p.status).Should(Equal(podStatusTimedOut))
+		})
+		It("should delete a restored pod that exceeds the timeout", func() {
+			deleted := make(chan bool, 1)
+			err := c.Restore("job", []PodState{{
+				ExecutionID: "restored",
+				Node:        "node",
+				Started:     time.Now().Add(-time.Minute),
+				Pod:         &corev1.Pod{},
+				Delete: func() error {
+					deleted <- true
+					return nil
+				},
+			}})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Eventually(deleted, 3*time.Second).Should(Receive())
+			Eventually(recorder.Events).Should(Receive(ContainSubstring("TimedOut")))
+
+			p, err := c.jobs["job"].executions["restored"].pod("node")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.status).Should(Equal(podStatusTimedOut))
+		})
+	})
+	Context("completion", func() {
+		var (
+			c *cache
+		)
+		BeforeEach(func() {
+			cfg.PodPoolSize = 2
+			cfg.ReportHistory = 5
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should record the start, completion and outcome of executions", func() {
+			prom := c.jobs["job"].prom
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(testutil.ToFloat64(prom.startGauge)).Should(BeNumerically(">", 0))
+
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "a"})).ShouldNot(HaveOccurred())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "b"})).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+			c.ReportReceived("job", id, "a", nil, Results{})
+			Ω(c.PodTerminated("job", id, "a", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+			Ω(testutil.ToFloat64(prom.completeGauge)).Should(BeZero())
+
+			Ω(c.PodTerminated("job", id, "b", 1, corev1.PodFailed)).ShouldNot(HaveOccurred())
+			Ω(testutil.ToFloat64(prom.completeGauge)).Should(BeNumerically(">", 0))
+			Ω(testutil.ToFloat64(prom.outcomeCounter.WithLabelValues(outcomePartiallyFailed))).Should(Equal(1.))
+
+			// the completion is only recorded once
+			c.ReportReceived("job", id, "b", nil, Results{})
+			Ω(testutil.ToFloat64(prom.outcomeCounter.WithLabelValues(outcomePartiallyFailed))).Should(Equal(1.))
+			Ω(testutil.ToFloat64(prom.outcomeCounter.WithLabelValues(outcomeSucceeded))).Should(BeZero())
+		})
+		It("should write the summary and call the completion hooks", func() {
+			hook := &testHook{summaries: make(chan ExecutionInfo, 1)}
+			c.AddCompletionHook(hook)
+
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "a"})).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			Ω(ioutil.WriteFile(filepath.Join(repDir, id, "a-log.txt"), []byte("log"), 0644)).ShouldNot(HaveOccurred())
+			c.ReportReceived("job", id, "a", nil, Results{})
+			Ω(c.PodTerminated("job", id, "a", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+
+			var summary ExecutionInfo
+			Eventually(hook.summaries).Should(Receive(&summary))
+			Ω(summary.ExecutionID).Should(Equal(id))
+			Ω(summary.Outcome).Should(Equal(outcomeSucceeded))
+			Ω(summary.Finished).ShouldNot(BeNil())
+			Ω(summary.Nodes).Should(HaveLen(1))
+			Ω(summary.Nodes[0].ReportReceived).ShouldNot(BeNil())
+			Ω(summary.Nodes[0].Files).Should(Equal([]string{"a-log.txt"}))
+
+			b, err := ioutil.ReadFile(filepath.Join(repDir, id, SummaryFileName))
+			Ω(err).ShouldNot(HaveOccurred())
+			var written ExecutionInfo
+			Ω(json.Unmarshal(b, &written)).ShouldNot(HaveOccurred())
+			Ω(written.ExecutionID).Should(Equal(id))
+			Ω(written.Outcome).Should(Equal(outcomeSucceeded))
+			Ω(written.Nodes).Should(HaveLen(1))
+
+			info, err := c.Execution("job", id)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Outcome).Should(Equal(outcomeSucceeded))
+			Ω(info.Files).Should(ContainElement(SummaryFileName))
+		})
+		It("should count succeeded executions", func() {
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "a"})).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			c.ReportReceived("job", id, "a", nil, Results{})
+			Ω(c.PodTerminated("job", id, "a", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+
+			Ω(testutil.ToFloat64(c.jobs["job"].prom.outcomeCounter.WithLabelValues(outcomeSucceeded))).Should(Equal(1.))
+		})
+		It("should count cancelled and skipped executions", func() {
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "a"})).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			Ω(c.Cancel("job", id)).ShouldNot(HaveOccurred())
+			c.ExecutionOverlapped("job", OverlapSkipped)
+			c.ExecutionOverlapped("job", OverlapAllowed)
+
+			prom := c.jobs["job"].prom
+			Ω(testutil.ToFloat64(prom.outcomeCounter.WithLabelValues(outcomeCancelled))).Should(Equal(1.))
+			Ω(testutil.ToFloat64(prom.outcomeCounter.WithLabelValues(outcomeSkipped))).Should(Equal(1.))
+		})
+	})
+	Context("AddListener", func() {
+		var (
+			c        *cache
+			listener *testListener
+		)
+		BeforeEach(func() {
+			cfg.PodPoolSize = 1
+			cfg.ReportHistory = 5
+			cc, err := NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			c = cc.(*cache)
+			listener = &testListener{}
+			c.AddListener(listener)
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should notify the listener on status changes", func() {
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "node"})).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			Ω(listener.status.Job).Should(Equal("job"))
+			Ω(listener.status.ExecutionID).Should(Equal(id))
+			Ω(listener.status.PodsStarted).Should(Equal(1))
+			Ω(listener.status.PodsSucceeded).Should(Equal(0))
+
+			Ω(c.Has("job", "node", id)).Should(BeTrue())
+			Ω(c.Has("other", "node", id)).Should(BeFalse())
+
+			c.ReportReceived("job", id, "node", nil, Results{})
+			Ω(listener.status.LastReportTime).ShouldNot(BeNil())
+
+			Ω(c.PodTerminated("job", id, "node", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+			Ω(listener.status.PodsSucceeded).Should(Equal(1))
+			Ω(listener.status.PodsFailed).Should(Equal(0))
+		})
 	})
 })
+
+type testListener struct {
+	status ExecutionStatus
+}
+
+func (l *testListener) StatusChanged(status ExecutionStatus) {
+	l.status = status
+}
+
+type testHook struct {
+	summaries chan ExecutionInfo
+}
+
+func (h *testHook) ExecutionCompleted(summary ExecutionInfo) {
+	h.summaries <- summary
+}
+
+type testJob struct {
+	id      string
+	job     string
+	node    string
+	attempt int
+	deleted chan bool
+}
+
+func (j *testJob) Process() error {
+	return nil
+}
+
+func (j *testJob) Attempt() int {
+	if j.attempt == 0 {
+		return 1
+	}
+	return j.attempt
+}
+
+func (j *testJob) Retry() Job {
+	next := *j
+	next.attempt = j.Attempt() + 1
+	return &next
+}
+
+func (j *testJob) Delete() error {
+	if j.deleted != nil {
+		j.deleted <- true
+	}
+	return nil
+}
+
+func (j *testJob) Pod() *corev1.Pod {
+	return &corev1.Pod{}
+}
+
+func (j *testJob) ID() string {
+	return j.id
+}
+
+func (j *testJob) Node() string {
+	return j.node
+}
+
+func (j *testJob) JobName() string {
+	return j.job
+}
@@ -2,9 +2,13 @@ package lifecycle
 
 import (
 	"fmt"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/bakito/batch-job-controller/pkg/config"
 	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
@@ -17,65 +21,254 @@ var (
 	procErrorMetric = "processing"
 	durationMetric  = "duration"
 	podsMetric      = "pods"
+	attemptsMetric  = "attempts"
+	cancelledMetric = "cancelled"
+	overlapMetric   = "overlapping_executions_total"
+	nextMetric      = "next_schedule_time_seconds"
+	startMetric     = "last_execution_start_time_seconds"
+	completeMetric  = "last_execution_completion_time_seconds"
+	outcomeMetric   = "executions_total"
+
+	reservedMetrics = []string{procErrorMetric, durationMetric, podsMetric, attemptsMetric, cancelledMetric, overlapMetric,
+		nextMetric, startMetric, completeMetric, outcomeMetric}
+
+	labelDecision = "decision"
+	labelOutcome  = "outcome"
+
+	outcomeSucceeded       = "succeeded"
+	outcomePartiallyFailed = "partially_failed"
+	outcomeSkipped         = "skipped"
+	outcomeCancelled       = "cancelled"
 )
 
 // Collector strunct
 type Collector struct {
-	gauges         map[string]customMetric
+	custom         map[string]customMetric
 	procErrorGauge *prom.GaugeVec
 	durationGauge  *prom.GaugeVec
 	podsGauge      *prom.GaugeVec
+	attemptsGauge  *prom.GaugeVec
+	cancelledGauge *prom.GaugeVec
+	overlapCounter *prom.CounterVec
+	nextGauge      prom.Gauge
+	startGauge     prom.Gauge
+	completeGauge  prom.Gauge
+	outcomeCounter *prom.CounterVec
 	namespace      string
+	prefix         string
+	metrics        config.Metrics
+	lock           sync.RWMutex
 }
 
 // Describe returns all the descriptions of the collector
 func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	c.procErrorGauge.Describe(ch)
 	c.durationGauge.Describe(ch)
 	c.podsGauge.Describe(ch)
-	for k := range c.gauges {
-		c.gauges[k].gauge.Describe(ch)
+	c.attemptsGauge.Describe(ch)
+	c.cancelledGauge.Describe(ch)
+	c.overlapCounter.Describe(ch)
+	c.nextGauge.Describe(ch)
+	c.startGauge.Describe(ch)
+	c.completeGauge.Describe(ch)
+	c.outcomeCounter.Describe(ch)
+	for k := range c.custom {
+		c.custom[k].vec.Describe(ch)
 	}
 }
 
 // Collect returns the current state of the metrics
 func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	c.procErrorGauge.Collect(ch)
 	c.durationGauge.Collect(ch)
 	c.podsGauge.Collect(ch)
-	for k := range c.gauges {
-		c.gauges[k].gauge.Collect(ch)
+	c.attemptsGauge.Collect(ch)
+	c.cancelledGauge.Collect(ch)
+	c.overlapCounter.Collect(ch)
+	c.nextGauge.Collect(ch)
+	c.startGauge.Collect(ch)
+	c.completeGauge.Collect(ch)
+	c.outcomeCounter.Collect(ch)
+	for k := range c.custom {
+		c.custom[k].vec.Collect(ch)
+	}
+}
+
+// results apply all results of a node
+func (c *Collector) results(executionID string, node string, results Results) {
+	for name, res := range results {
+		for _, r := range res {
+			c.metricFor(executionID, node, name, r)
+		}
+	}
+}
+
+// splitResults separate the results of cumulative metrics, which must be counted only once per pod, from the others
+func (c *Collector) splitResults(results Results) (Results, Results) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	set := make(Results)
+	cumulative := make(Results)
+	for name, res := range results {
+		if m, ok := c.custom[name]; ok && m.cumulative {
+			cumulative[name] = res
+		} else {
+			set[name] = res
+		}
 	}
+	return set, cumulative
 }
 
 func (c *Collector) metricFor(executionID string, node string, name string, result Result) {
-	if _, ok := c.gauges[name]; ok {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if m, ok := c.custom[name]; ok {
 		if result.Labels == nil {
 			result.Labels = make(map[string]string)
 		}
 		result.Labels[labelNode] = node
 		result.Labels[labelExecutionId] = executionID
 		var labels []string
-		for _, l := range c.gauges[name].labels {
+		for _, l := range m.labels {
 			labels = append(labels, result.Labels[l])
 		}
-		c.gauges[name].gauge.WithLabelValues(labels...).Set(result.Value)
+		m.observe(labels, result)
 	}
 }
 
 func (c *Collector) processingError(name string, executionId string, err bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	value := 0.
 	if err {
 		value = 1
 	}
-	c.procErrorGauge.WithLabelValues(name, executionId).Set(value)
+	c.procErrorGauge.WithLabelValues(c.nodeValues(name, executionId)...).Set(value)
 }
 
 func (c *Collector) duration(name string, executionId string, d float64) {
-	c.durationGauge.WithLabelValues(name, executionId).Set(d)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.durationGauge.WithLabelValues(c.nodeValues(name, executionId)...).Set(d)
+}
+
+func (c *Collector) attempts(name string, executionId string, attempt int) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.attemptsGauge.WithLabelValues(c.nodeValues(name, executionId)...).Set(float64(attempt))
+}
+
+func (c *Collector) cancelled(executionId string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.metrics.DropExecutionIDLabel {
+		c.cancelledGauge.WithLabelValues().Set(1)
+		return
+	}
+	c.cancelledGauge.WithLabelValues(executionId).Set(1)
+}
+
+// nodeValues get the label values of the metrics by node, the lock must be held
+func (c *Collector) nodeValues(node string, executionId string) []string {
+	if c.metrics.DropExecutionIDLabel {
+		return []string{node}
+	}
+	return []string{node, executionId}
+}
+
+// executionPruned delete all series of the execution
+func (c *Collector) executionPruned(executionId string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.metrics.DropExecutionIDLabel {
+		return
+	}
+	c.deleteSeries(labelExecutionId, executionId)
+}
+
+// nodeRemoved delete all series of the node
+func (c *Collector) nodeRemoved(node string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.deleteSeries(labelNode, node)
+}
+
+// deleteSeries delete the series of the execution and node metrics with the given label value, the lock must be held
+func (c *Collector) deleteSeries(label string, value string) {
+	vecs := []metricVec{c.procErrorGauge, c.durationGauge, c.attemptsGauge, c.cancelledGauge}
+	for k := range c.custom {
+		vecs = append(vecs, c.custom[k].vec)
+	}
+	for _, vec := range vecs {
+		for _, labels := range matchingSeries(vec, label, value) {
+			vec.Delete(labels)
+		}
+	}
+}
+
+// matchingSeries collect the labels of all series of the vector with the given label value
+func matchingSeries(vec metricVec, label string, value string) []prom.Labels {
+	ch := make(chan prom.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+
+	var matching []prom.Labels
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			continue
+		}
+		labels := make(prom.Labels)
+		for _, lp := range pb.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if v, ok := labels[label]; ok && v == value {
+			matching = append(matching, labels)
+		}
+	}
+	return matching
+}
+
+func (c *Collector) overlapped(decision string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.overlapCounter.WithLabelValues(decision).Inc()
+}
+
+func (c *Collector) nextSchedule(next time.Time) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.nextGauge.Set(float64(next.Unix()))
+}
+
+func (c *Collector) executionStarted(started time.Time) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.startGauge.Set(float64(started.Unix()))
+}
+
+func (c *Collector) executionCompleted(completed time.Time, outcome string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.completeGauge.Set(float64(completed.Unix()))
+	c.outcomeCounter.WithLabelValues(outcome).Inc()
+}
+
+func (c *Collector) executionSkipped() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.outcomeCounter.WithLabelValues(outcomeSkipped).Inc()
 }
 
 func (c *Collector) pods(cnt float64) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	g, err := c.podsGauge.GetMetricWithLabelValues()
 	if err == nil {
 		g.Set(cnt)
@@ -86,61 +279,187 @@ func (c *Collector) pods(cnt float64) {
 func NewPromCollector(cfg *config.Config) (*Collector, error) {
 
 	c := &Collector{
-		gauges:    make(map[string]customMetric),
+		custom:    make(map[string]customMetric),
 		namespace: cfg.Namespace,
 	}
-	c.procErrorGauge = prom.NewGaugeVec(prom.GaugeOpts{
-		Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, procErrorMetric),
-		Help: "Node with processing error, 1: has error / 0: no error",
-	}, []string{labelNode, labelExecutionId})
-
-	c.durationGauge =
-		prom.NewGaugeVec(prom.GaugeOpts{
-			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, durationMetric),
-			Help: "execution duration in milliseconds",
-		}, []string{labelNode, labelExecutionId})
-
-	c.podsGauge = prom.NewGaugeVec(prom.GaugeOpts{
-		Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, podsMetric),
-		Help: "the number of pods started for the last execution",
-	}, []string{})
-
-	for name, metric := range cfg.Metrics.Gauges {
-		if name == procErrorMetric || name == durationMetric || name == podsMetric {
-			return nil, fmt.Errorf("the metric name %q is not allowed, it's one of the reserved names: %v",
-				name, []string{procErrorMetric, durationMetric, podsMetric})
-		}
+	if err := ValidateMetrics(cfg); err != nil {
+		return nil, err
+	}
+	c.update(cfg)
 
-		labels := enrichLabels(metric.Labels)
+	if err := metrics.Registry.Register(c); err != nil {
+		return nil, fmt.Errorf("could not register the metrics of job %q: %v", cfg.Name, err)
+	}
+	return c, nil
+}
 
-		c.gauges[name] = customMetric{
-			labels: labels,
-			gauge: prom.NewGaugeVec(prom.GaugeOpts{
-				Name: cfg.Metrics.NameFor(name),
-				Help: metric.Help,
-			}, labels),
+// ValidateMetrics check if the metrics of the config can be used by the collector
+func ValidateMetrics(cfg *config.Config) error {
+	for name := range cfg.Metrics.Definitions() {
+		for _, reserved := range reservedMetrics {
+			if name == reserved {
+				return fmt.Errorf("the metric name %q is not allowed, it's one of the reserved names: %v", name, reservedMetrics)
+			}
 		}
+	}
+	return nil
+}
 
+// Update apply a changed metrics config and re-register the collector
+func (c *Collector) Update(cfg *config.Config) error {
+	if err := ValidateMetrics(cfg); err != nil {
+		return err
+	}
+	c.lock.RLock()
+	unchanged := c.prefix == cfg.Metrics.Prefix && reflect.DeepEqual(c.metrics, cfg.Metrics)
+	c.lock.RUnlock()
+	if unchanged {
+		return nil
 	}
 
 	metrics.Registry.Unregister(c)
-	metrics.Registry.MustRegister(c)
-	return c, nil
+	c.update(cfg)
+	return metrics.Registry.Register(c)
+}
+
+func (c *Collector) update(cfg *config.Config) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dropChanged := c.metrics.DropExecutionIDLabel != cfg.Metrics.DropExecutionIDLabel
+	if c.prefix != cfg.Metrics.Prefix || c.procErrorGauge == nil || dropChanged {
+		nodeLabels := []string{labelNode, labelExecutionId}
+		executionLabels := []string{labelExecutionId}
+		if cfg.Metrics.DropExecutionIDLabel {
+			nodeLabels = []string{labelNode}
+			executionLabels = []string{}
+		}
+
+		c.procErrorGauge = prom.NewGaugeVec(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, procErrorMetric),
+			Help: "Node with processing error, 1: has error / 0: no error",
+		}, nodeLabels)
+
+		c.durationGauge =
+			prom.NewGaugeVec(prom.GaugeOpts{
+				Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, durationMetric),
+				Help: "execution duration in milliseconds",
+			}, nodeLabels)
+
+		c.podsGauge = prom.NewGaugeVec(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, podsMetric),
+			Help: "the number of pods started for the last execution",
+		}, []string{})
+
+		c.attemptsGauge = prom.NewGaugeVec(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, attemptsMetric),
+			Help: "the current attempt of the pod of a node",
+		}, nodeLabels)
+
+		c.cancelledGauge = prom.NewGaugeVec(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, cancelledMetric),
+			Help: "Execution was cancelled, 1: cancelled",
+		}, executionLabels)
+
+		c.overlapCounter = prom.NewCounterVec(prom.CounterOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, overlapMetric),
+			Help: "the number of new executions that overlapped with an active execution by the decision of the concurrency policy",
+		}, []string{labelDecision})
+
+		c.nextGauge = prom.NewGauge(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, nextMetric),
+			Help: "the unix time in seconds of the next scheduled execution",
+		})
+
+		c.startGauge = prom.NewGauge(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, startMetric),
+			Help: "the unix time in seconds the last execution was started",
+		})
+
+		c.completeGauge = prom.NewGauge(prom.GaugeOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, completeMetric),
+			Help: "the unix time in seconds the last execution was completed",
+		})
+
+		c.outcomeCounter = prom.NewCounterVec(prom.CounterOpts{
+			Name: fmt.Sprintf("%s_%s", cfg.Metrics.Prefix, outcomeMetric),
+			Help: "the number of executions by outcome: succeeded, partially_failed, cancelled or skipped",
+		}, []string{labelOutcome})
+	}
+
+	custom := make(map[string]customMetric)
+	oldDefs := c.metrics.Definitions()
+	for name, def := range cfg.Metrics.Definitions() {
+		// keep the values of unchanged metrics
+		if old, ok := oldDefs[name]; ok && c.prefix == cfg.Metrics.Prefix && !dropChanged && reflect.DeepEqual(old, def) {
+			custom[name] = c.custom[name]
+			continue
+		}
+		custom[name] = newCustomMetric(cfg.Metrics.NameFor(name), def, cfg.Metrics.DropExecutionIDLabel)
+	}
+
+	c.custom = custom
+	c.prefix = cfg.Metrics.Prefix
+	c.metrics = cfg.Metrics
+}
+
+// newCustomMetric create the prometheus vector matching the type of the metric
+func newCustomMetric(name string, def config.MetricDefinition, dropExecutionID bool) customMetric {
+	labels := enrichLabels(def.Labels, dropExecutionID)
+	switch def.Type {
+	case config.MetricTypeCounter:
+		vec := prom.NewCounterVec(prom.CounterOpts{Name: name, Help: def.Help}, labels)
+		return customMetric{vec: vec, labels: labels, cumulative: true, observe: func(values []string, result Result) {
+			vec.WithLabelValues(values...).Add(result.Value)
+		}}
+	case config.MetricTypeHistogram:
+		buckets := def.Buckets
+		if len(buckets) == 0 {
+			buckets = prom.DefBuckets
+		}
+		vec := prom.NewHistogramVec(prom.HistogramOpts{Name: name, Help: def.Help, Buckets: buckets}, labels)
+		return customMetric{vec: vec, labels: labels, cumulative: true, observe: func(values []string, result Result) {
+			h := vec.WithLabelValues(values...)
+			for _, v := range result.Observations() {
+				h.Observe(v)
+			}
+		}}
+	case config.MetricTypeInfo:
+		vec := prom.NewGaugeVec(prom.GaugeOpts{Name: name, Help: def.Help}, labels)
+		return customMetric{vec: vec, labels: labels, observe: func(values []string, _ Result) {
+			vec.WithLabelValues(values...).Set(1)
+		}}
+	default:
+		vec := prom.NewGaugeVec(prom.GaugeOpts{Name: name, Help: def.Help}, labels)
+		return customMetric{vec: vec, labels: labels, observe: func(values []string, result Result) {
+			vec.WithLabelValues(values...).Set(result.Value)
+		}}
+	}
 }
 
-func enrichLabels(labels []string) []string {
-	out := labels
+func enrichLabels(labels []string, dropExecutionID bool) []string {
+	var out []string
 	m := make(map[string]bool)
 	for _, l := range labels {
+		if l == labelExecutionId && dropExecutionID {
+			continue
+		}
+		out = append(out, l)
 		m[l] = true
 	}
 
 	if _, ok := m[labelNode]; !ok {
 		out = append(out, labelNode)
 	}
-	if _, ok := m[labelExecutionId]; !ok {
+	if _, ok := m[labelExecutionId]; !ok && !dropExecutionID {
 		out = append(out, labelExecutionId)
 	}
 
 	return out
 }
+
+// metricVec a prometheus vector that allows to delete its series
+type metricVec interface {
+	prom.Collector
+	Delete(labels prom.Labels) bool
+}
@@ -1,10 +1,18 @@
 package lifecycle_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var _ = Describe("metrics", func() {
@@ -23,5 +31,212 @@ var _ = Describe("metrics", func() {
 			_, err := lifecycle.NewPromCollector(cfg)
 			Ω(err).ShouldNot(HaveOccurred())
 		})
+		It("should fail if the metrics are already registered", func() {
+			_, err := lifecycle.NewPromCollector(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = lifecycle.NewPromCollector(cfg)
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should be invalid with a reserved metric name", func() {
+			cfg.Metrics.Gauges = map[string]config.Metric{"pods": {}}
+			_, err := lifecycle.NewPromCollector(cfg)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+	Context("Update", func() {
+		var (
+			c   *lifecycle.Collector
+			cfg *config.Config
+		)
+		BeforeEach(func() {
+			cfg = &config.Config{
+				Metrics: config.Metrics{
+					Prefix: "update",
+					Gauges: map[string]config.Metric{"a": {Help: "a"}},
+				},
+			}
+			c, _ = lifecycle.NewPromCollector(cfg)
+		})
+		It("should register the changed gauges", func() {
+			err := c.Update(&config.Config{
+				Metrics: config.Metrics{
+					Prefix: "bar",
+					Gauges: map[string]config.Metric{"b": {Help: "b"}},
+				},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			ch := make(chan *prom.Desc, 20)
+			c.Describe(ch)
+			close(ch)
+			var descs []string
+			for d := range ch {
+				descs = append(descs, d.String())
+			}
+			Ω(descs).Should(ContainElement(ContainSubstring(`"bar_b"`)))
+			Ω(descs).ShouldNot(ContainElement(ContainSubstring(`"update_a"`)))
+		})
+		It("should keep the current gauges if the change is invalid", func() {
+			err := c.Update(&config.Config{
+				Metrics: config.Metrics{
+					Prefix: "update",
+					Gauges: map[string]config.Metric{"duration": {}},
+				},
+			})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+	Context("metric types", func() {
+		var (
+			c      lifecycle.Cache
+			repDir string
+		)
+		BeforeEach(func() {
+			var err error
+			repDir, err = ioutil.TempDir("", "lifecycle")
+			Ω(err).ShouldNot(HaveOccurred())
+			c, err = lifecycle.NewCache(&config.Config{
+				Name:            "job",
+				ReportDirectory: repDir,
+				Metrics: config.Metrics{
+					Prefix:   "types",
+					Gauges:   map[string]config.Metric{"gauge": {Help: "g", Labels: []string{"l"}}},
+					Counters: map[string]config.Metric{"counter": {Help: "c", Labels: []string{"l"}}},
+					Histograms: map[string]config.Histogram{
+						"histogram": {Metric: config.Metric{Help: "h", Labels: []string{"l"}}, Buckets: []float64{1, 5}},
+					},
+					Info: map[string]config.Metric{"info": {Help: "i", Labels: []string{"version"}}},
+				},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should expose the reported results by type", func() {
+			for i := 0; i < 2; i++ {
+				c.ReportReceived("job", "id", "node", nil, lifecycle.Results{
+					"gauge":     []lifecycle.Result{{Value: 3, Labels: map[string]string{"l": "a"}}},
+					"counter":   []lifecycle.Result{{Value: 3, Labels: map[string]string{"l": "a"}}},
+					"histogram": []lifecycle.Result{{Values: []float64{0.5, 2, 10}, Labels: map[string]string{"l": "a"}}, {Value: 4, Labels: map[string]string{"l": "a"}}},
+					"info":      []lifecycle.Result{{Value: 42, Labels: map[string]string{"version": "1.0"}}},
+				})
+			}
+
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP types_counter c
+# TYPE types_counter counter
+types_counter{executionID="id",l="a",node="node"} 6
+# HELP types_gauge g
+# TYPE types_gauge gauge
+types_gauge{executionID="id",l="a",node="node"} 3
+# HELP types_histogram h
+# TYPE types_histogram histogram
+types_histogram_bucket{executionID="id",l="a",node="node",le="1"} 2
+types_histogram_bucket{executionID="id",l="a",node="node",le="5"} 6
+types_histogram_bucket{executionID="id",l="a",node="node",le="+Inf"} 8
+types_histogram_sum{executionID="id",l="a",node="node"} 33
+types_histogram_count{executionID="id",l="a",node="node"} 8
+# HELP types_info i
+# TYPE types_info gauge
+types_info{executionID="id",node="node",version="1.0"} 1
+`), "types_counter", "types_gauge", "types_histogram", "types_info")).ShouldNot(HaveOccurred())
+		})
+	})
+	Context("cardinality", func() {
+		var (
+			cfg    *config.Config
+			repDir string
+			report lifecycle.Results
+		)
+		BeforeEach(func() {
+			var err error
+			repDir, err = ioutil.TempDir("", "lifecycle")
+			Ω(err).ShouldNot(HaveOccurred())
+			cfg = &config.Config{
+				Name:            "job",
+				ReportDirectory: repDir,
+				ReportHistory:   1,
+				Metrics: config.Metrics{
+					Gauges: map[string]config.Metric{"g": {Help: "g"}},
+				},
+			}
+			report = lifecycle.Results{"g": []lifecycle.Result{{Value: 1}}}
+		})
+		AfterEach(func() {
+			os.RemoveAll(repDir)
+		})
+		It("should expose the latest values without execution id", func() {
+			cfg.Metrics.Prefix = "drop"
+			cfg.Metrics.DropExecutionIDLabel = true
+			c, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			c.ReportReceived("job", "id1", "node", nil, report)
+			c.ReportReceived("job", "id2", "node", fmt.Errorf("error"), lifecycle.Results{"g": []lifecycle.Result{{Value: 2}}})
+
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP drop_g g
+# TYPE drop_g gauge
+drop_g{node="node"} 2
+# HELP drop_processing Node with processing error, 1: has error / 0: no error
+# TYPE drop_processing gauge
+drop_processing{node="node"} 1
+`), "drop_g", "drop_processing")).ShouldNot(HaveOccurred())
+		})
+		It("should delete the series of pruned executions", func() {
+			cfg.Metrics.Prefix = "prune"
+			c, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var ids []string
+			for i := 0; i < 2; i++ {
+				id, err := c.NewExecution("job")
+				Ω(err).ShouldNot(HaveOccurred())
+				c.ReportReceived("job", id, "node", nil, report)
+				Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+				ids = append(ids, id)
+			}
+
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(fmt.Sprintf(`
+# HELP prune_g g
+# TYPE prune_g gauge
+prune_g{executionID="%[1]s",node="node"} 1
+# HELP prune_processing Node with processing error, 1: has error / 0: no error
+# TYPE prune_processing gauge
+prune_processing{executionID="%[1]s",node="node"} 0
+`, ids[1])), "prune_g", "prune_processing")).ShouldNot(HaveOccurred())
+		})
+		It("should delete the series of removed nodes of jobs with node targets", func() {
+			cfg.Metrics.Prefix = "noderm"
+			c, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			c.ReportReceived("job", "id", "node1", nil, report)
+			c.ReportReceived("job", "id", "node2", nil, report)
+			c.NodeRemoved("node1")
+
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP noderm_g g
+# TYPE noderm_g gauge
+noderm_g{executionID="id",node="node2"} 1
+`), "noderm_g")).ShouldNot(HaveOccurred())
+			Ω(c.Has("job", "node1", "id")).Should(BeFalse())
+		})
+		It("should keep the series of other targets", func() {
+			cfg.Metrics.Prefix = "nodekeep"
+			cfg.Target = config.Target{Kind: config.TargetKindNamespace}
+			c, err := lifecycle.NewCache(cfg)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			c.ReportReceived("job", "id", "node1", nil, report)
+			c.NodeRemoved("node1")
+
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP nodekeep_g g
+# TYPE nodekeep_g gauge
+nodekeep_g{executionID="id",node="node1"} 1
+`), "nodekeep_g")).ShouldNot(HaveOccurred())
+		})
 	})
 })
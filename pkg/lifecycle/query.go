@@ -0,0 +1,165 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Executions get the summary of all known executions of a job ordered by execution id
+func (c *cache) Executions(jobName string) ([]ExecutionInfo, error) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return nil, err
+	}
+	var infos []ExecutionInfo
+	for _, e := range j.executionList() {
+		info := e.info(j.name)
+		info.Nodes = nil
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].ExecutionID < infos[b].ExecutionID
+	})
+	return infos, nil
+}
+
+// Execution get the state of an execution including its nodes and files
+func (c *cache) Execution(jobName string, executionID string) (*ExecutionInfo, error) {
+	j, e, err := c.forID(jobName, executionID)
+	if err != nil {
+		return nil, err
+	}
+	info := e.info(j.name)
+	info.Files = j.files(executionID)
+
+	nodes := make([]string, len(info.Nodes))
+	for i := range info.Nodes {
+		nodes[i] = info.Nodes[i].Node
+	}
+	for i := range info.Nodes {
+		info.Nodes[i].Files = filesOf(info.Nodes[i].Node, nodes, info.Files)
+	}
+	return &info, nil
+}
+
+// Node get the state of the pod of a node within an execution
+func (c *cache) Node(jobName string, executionID string, node string) (*NodeInfo, error) {
+	info, err := c.Execution(jobName, executionID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range info.Nodes {
+		if info.Nodes[i].Node == node {
+			return &info.Nodes[i], nil
+		}
+	}
+	return nil, &ExecutionIDNotFound{Err: fmt.Errorf("node '%s' not found in execution '%s'", node, executionID)}
+}
+
+// files get the names of the files in the report directory of an execution
+func (j *jobCache) files(executionID string) []string {
+	j.configLock.RLock()
+	dir := filepath.Join(j.reportDir, executionID)
+	j.configLock.RUnlock()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+// filesOf get the files uploaded by the pod of a node.
+// Files are prefixed with the node name, if multiple nodes match the longest node name wins.
+func filesOf(node string, nodes []string, files []string) []string {
+	var result []string
+	for _, f := range files {
+		owner := ""
+		for _, n := range nodes {
+			if (f == n+".json" || strings.HasPrefix(f, n+"-")) && len(n) > len(owner) {
+				owner = n
+			}
+		}
+		if owner == node {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (e *execution) info(jobName string) ExecutionInfo {
+	info := ExecutionInfo{
+		Job:          jobName,
+		ExecutionID:  e.id,
+		Cancelled:    e.isCancelled(),
+		PodsByStatus: make(map[string]int),
+	}
+
+	e.lock.RLock()
+	started := e.started
+	finished := e.added
+	info.Outcome = e.result
+	e.lock.RUnlock()
+
+	var lastTerminated time.Time
+	e.Map.Range(func(_, value interface{}) bool {
+		n := value.(*pod).info()
+		info.Nodes = append(info.Nodes, n)
+		info.Pods++
+		info.PodsByStatus[n.Status]++
+
+		if n.Started != nil && (started.IsZero() || n.Started.Before(started)) {
+			started = *n.Started
+		}
+		if n.Terminated == nil {
+			finished = false
+		} else if n.Terminated.After(lastTerminated) {
+			lastTerminated = *n.Terminated
+		}
+		return true
+	})
+	sort.Slice(info.Nodes, func(a, b int) bool {
+		return info.Nodes[a].Node < info.Nodes[b].Node
+	})
+
+	if !started.IsZero() {
+		info.Started = &started
+	}
+	if finished && info.Pods > 0 {
+		info.Finished = &lastTerminated
+		if info.Started != nil {
+			info.DurationMillis = lastTerminated.Sub(started).Milliseconds()
+		}
+	}
+	return info
+}
+
+func (p *pod) info() NodeInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	info := NodeInfo{
+		Node:           p.node,
+		Status:         p.status,
+		Attempt:        p.attempt,
+		Terminated:     p.terminated,
+		ReportReceived: p.reportReceived,
+	}
+	if !p.started.IsZero() {
+		started := p.started
+		info.Started = &started
+		if p.terminated != nil {
+			info.DurationMillis = p.terminated.Sub(started).Milliseconds()
+		}
+	}
+	return info
+}
@@ -0,0 +1,99 @@
+package lifecycle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("query", func() {
+	var (
+		c      *cache
+		repDir string
+		id     string
+	)
+	BeforeEach(func() {
+		var err error
+		repDir, err = ioutil.TempDir("", "lifecycle")
+		Ω(err).ShouldNot(HaveOccurred())
+		cc, err := NewCache(&config.Config{
+			Name:            "job",
+			ReportDirectory: repDir,
+			ReportHistory:   5,
+			PodPoolSize:     2,
+			Metrics: config.Metrics{
+				Prefix: "query",
+			},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+		c = cc.(*cache)
+
+		id, err = c.NewExecution("job")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(c.AddPod(&testJob{id: id, job: "job", node: "node"})).ShouldNot(HaveOccurred())
+		Ω(c.AddPod(&testJob{id: id, job: "job", node: "node-b"})).ShouldNot(HaveOccurred())
+		Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+		for _, f := range []string{"node.json", "node-b.json", "node-log.txt", "node-b-log.txt"} {
+			Ω(ioutil.WriteFile(filepath.Join(repDir, id, f), []byte("{}"), 0644)).ShouldNot(HaveOccurred())
+		}
+	})
+	AfterEach(func() {
+		os.RemoveAll(repDir)
+	})
+
+	It("should list the executions", func() {
+		infos, err := c.Executions("job")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(infos).Should(HaveLen(1))
+		Ω(infos[0].ExecutionID).Should(Equal(id))
+		Ω(infos[0].Pods).Should(Equal(2))
+		Ω(infos[0].Started).ShouldNot(BeNil())
+		Ω(infos[0].Finished).Should(BeNil())
+		Ω(infos[0].Nodes).Should(BeEmpty())
+
+		_, err = c.Executions("unknown")
+		Ω(err).Should(HaveOccurred())
+	})
+	It("should return the execution with nodes and files", func() {
+		c.ReportReceived("job", id, "node", nil, Results{})
+		Ω(c.PodTerminated("job", id, "node", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+		Ω(c.PodTerminated("job", id, "node-b", 1, corev1.PodFailed)).ShouldNot(HaveOccurred())
+
+		info, err := c.Execution("job", id)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.Finished).ShouldNot(BeNil())
+		Ω(info.PodsByStatus).Should(Equal(map[string]int{"Succeeded": 1, "Failed": 1}))
+		Ω(info.Outcome).Should(Equal(outcomePartiallyFailed))
+		// the summary is written on completion
+		Ω(info.Files).Should(HaveLen(5))
+		Ω(info.Files).Should(ContainElement(SummaryFileName))
+		Ω(info.Nodes).Should(HaveLen(2))
+		Ω(info.Nodes[0].Node).Should(Equal("node"))
+		Ω(info.Nodes[0].ReportReceived).ShouldNot(BeNil())
+		Ω(info.Nodes[0].Files).Should(ConsistOf("node.json", "node-log.txt"))
+		Ω(info.Nodes[1].Files).Should(ConsistOf("node-b.json", "node-b-log.txt"))
+	})
+	It("should return the active executions until all pods are terminated", func() {
+		Ω(c.ActiveExecutions("job")).Should(Equal([]string{id}))
+		Ω(c.PodTerminated("job", id, "node", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+		Ω(c.ActiveExecutions("job")).Should(Equal([]string{id}))
+		Ω(c.PodTerminated("job", id, "node-b", 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+		Ω(c.ActiveExecutions("job")).Should(BeEmpty())
+	})
+	It("should return a node", func() {
+		n, err := c.Node("job", id, "node-b")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(n.Node).Should(Equal("node-b"))
+		Ω(n.Attempt).Should(Equal(1))
+		Ω(n.Files).Should(ConsistOf("node-b.json", "node-b-log.txt"))
+
+		_, err = c.Node("job", id, "unknown")
+		Ω(err).Should(BeAssignableToTypeOf(&ExecutionIDNotFound{}))
+	})
+})
@@ -0,0 +1,60 @@
+package lifecycle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scheduleFile the file in the report directory of a job holding the last scheduled time
+const scheduleFile = ".last-schedule"
+
+// LastScheduled get the last time the job was scheduled by the cron, zero if unknown
+func (c *cache) LastScheduled(jobName string) (time.Time, error) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	j.scheduleLock.Lock()
+	defer j.scheduleLock.Unlock()
+	b, err := ioutil.ReadFile(j.scheduleFile())
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+}
+
+// Scheduled persist the time the job was scheduled by the cron
+func (c *cache) Scheduled(jobName string, t time.Time) error {
+	j, err := c.job(jobName)
+	if err != nil {
+		return err
+	}
+	j.scheduleLock.Lock()
+	defer j.scheduleLock.Unlock()
+	file := j.scheduleFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(t.Format(time.RFC3339)), 0644)
+}
+
+// NextSchedule expose the time of the next scheduled execution
+func (c *cache) NextSchedule(jobName string, next time.Time) {
+	j, err := c.job(jobName)
+	if err != nil {
+		return
+	}
+	j.prom.nextSchedule(next)
+}
+
+func (j *jobCache) scheduleFile() string {
+	j.configLock.RLock()
+	defer j.configLock.RUnlock()
+	return filepath.Join(j.reportDir, scheduleFile)
+}
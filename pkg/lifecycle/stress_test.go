@@ -0,0 +1,206 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the stress test is meant to be run with the race detector: go test -race ./pkg/lifecycle/...
+var _ = Describe("stress", func() {
+	var (
+		cfg    *config.Config
+		c      *cache
+		repDir string
+	)
+	BeforeEach(func() {
+		var err error
+		repDir, err = ioutil.TempDir("", "lifecycle")
+		Ω(err).ShouldNot(HaveOccurred())
+		cfg = &config.Config{
+			Name:            "job",
+			ReportDirectory: repDir,
+			ReportHistory:   3,
+			PodPoolSize:     4,
+			// pods that are not terminated by the readers free their worker after the timeout
+			JobTimeout: metav1.Duration{Duration: 50 * time.Millisecond},
+			Metrics: config.Metrics{
+				Prefix: "stress",
+				Gauges: map[string]config.Metric{
+					"test": {Help: "help", Labels: []string{"label_a"}},
+				},
+			},
+		}
+		cc, err := NewCache(cfg)
+		Ω(err).ShouldNot(HaveOccurred())
+		c = cc.(*cache)
+	})
+	AfterEach(func() {
+		os.RemoveAll(repDir)
+	})
+
+	It("should handle all cache methods in parallel", func() {
+		const (
+			executions = 20
+			pods       = 10
+			readers    = 8
+		)
+		var (
+			lock sync.Mutex
+			ids  []string
+		)
+		knownIDs := func() []string {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]string{}, ids...)
+		}
+		randomID := func() string {
+			known := knownIDs()
+			if len(known) == 0 {
+				return "unknown"
+			}
+			return known[rand.Intn(len(known))]
+		}
+		randomNode := func() string {
+			return fmt.Sprintf("node-%d", rand.Intn(pods))
+		}
+
+		stop := make(chan struct{})
+		var readersDone sync.WaitGroup
+		var calls int64
+		reader := func(call func()) {
+			readersDone.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer readersDone.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						call()
+						atomic.AddInt64(&calls, 1)
+					}
+				}
+			}()
+		}
+
+		for i := 0; i < readers; i++ {
+			reader(func() { c.Has("job", randomNode(), randomID()) })
+			reader(func() { _, _ = c.Executions("job") })
+			reader(func() { _, _ = c.Execution("job", randomID()) })
+			reader(func() { _, _ = c.Node("job", randomID(), randomNode()) })
+			reader(func() { _, _ = c.ActiveExecutions("job") })
+			reader(func() {
+				c.ReportReceived("job", randomID(), randomNode(), nil, Results{
+					"test": []Result{{Value: 1, Labels: map[string]string{"label_a": "a"}}},
+				})
+			})
+			reader(func() { _ = c.PodTerminated("job", randomID(), randomNode(), 1, corev1.PodSucceeded) })
+		}
+		reader(func() { _ = c.Config() })
+		reader(func() {
+			if rand.Intn(100) == 0 {
+				c.InjectConfig(cfg)
+			}
+		})
+		reader(func() { c.ExecutionOverlapped("job", OverlapAllowed) })
+		reader(func() { c.NextSchedule("job", time.Now()) })
+		reader(func() {
+			_ = c.Scheduled("job", time.Now())
+			_, err := c.LastScheduled("job")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		// listeners and hooks are called for every change, add a limited number only
+		reader(func() {
+			if rand.Intn(1000) == 0 {
+				c.AddListener(&countingListener{})
+			}
+		})
+		reader(func() {
+			if rand.Intn(1000) == 0 {
+				c.AddCompletionHook(&discardHook{})
+			}
+		})
+		reader(func() {
+			if rand.Intn(20) == 0 {
+				_ = c.Cancel("job", randomID())
+			}
+		})
+
+		var starters sync.WaitGroup
+		for i := 0; i < executions; i++ {
+			starters.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer starters.Done()
+				id, err := c.NewExecution("job")
+				Ω(err).ShouldNot(HaveOccurred())
+				lock.Lock()
+				ids = append(ids, id)
+				lock.Unlock()
+
+				for p := 0; p < pods; p++ {
+					// adding fails if the execution was cancelled in the meantime
+					_ = c.AddPod(&testJob{id: id, job: "job", node: fmt.Sprintf("node-%d", p)})
+				}
+				// the execution might already be pruned by a newer one
+				allAdded := func() {
+					if err := c.AllAdded("job", id); err != nil {
+						Ω(err).Should(BeAssignableToTypeOf(&ExecutionIDNotFound{}))
+					}
+				}
+				allAdded()
+				// pods can not be added anymore
+				Ω(c.AddPod(&testJob{id: id, job: "job", node: "late"})).Should(HaveOccurred())
+				allAdded()
+			}()
+		}
+		starters.Wait()
+
+		Ω(c.Restore("job", []PodState{{ExecutionID: randomID(), Node: "restored", Attempt: 1, Started: time.Now()}})).
+			ShouldNot(HaveOccurred())
+
+		close(stop)
+		readersDone.Wait()
+		Ω(atomic.LoadInt64(&calls)).Should(BeNumerically(">", 0))
+
+		// the ids are unique
+		unique := make(map[string]bool)
+		for _, id := range knownIDs() {
+			unique[id] = true
+		}
+		Ω(unique).Should(HaveLen(executions))
+
+		// stop the workers of the remaining executions
+		for _, id := range knownIDs() {
+			_ = c.Cancel("job", id)
+		}
+		Eventually(func() []string {
+			active, _ := c.ActiveExecutions("job")
+			return active
+		}).Should(BeEmpty())
+	})
+})
+
+type countingListener struct {
+	changes int64
+}
+
+func (l *countingListener) StatusChanged(ExecutionStatus) {
+	atomic.AddInt64(&l.changes, 1)
+}
+
+type discardHook struct{}
+
+func (h *discardHook) ExecutionCompleted(ExecutionInfo) {}
@@ -2,9 +2,14 @@ package lifecycle
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/bakito/batch-job-controller/pkg/config"
-	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // ExecutionIDNotFound custom error
@@ -16,27 +21,241 @@ func (e ExecutionIDNotFound) Error() string {
 	return e.Err.Error()
 }
 
+const (
+	// OverlapSkipped the new execution was skipped
+	OverlapSkipped = "skipped"
+	// OverlapReplaced the active execution was cancelled and replaced by the new execution
+	OverlapReplaced = "replaced"
+	// OverlapAllowed the new execution runs side by side with the active execution
+	OverlapAllowed = "allowed"
+)
+
+// ExecutionRunning custom error returned if an execution can not be started because the last one is still running
+type ExecutionRunning struct {
+	Err error
+}
+
+func (e ExecutionRunning) Error() string {
+	return e.Err.Error()
+}
+
 // Result metrics result
 type Result struct {
-	Value  float64           `json:"value"`
+	// Value is set for gauges, added for counters and observed for histograms, info metrics ignore the value
+	Value float64 `json:"value"`
+	// Values multiple observations of a histogram, observed instead of the value
+	Values []float64         `json:"values,omitempty"`
 	Labels map[string]string `json:"labels"`
 }
 
+// Observations get the values to be observed by a histogram
+func (r Result) Observations() []float64 {
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	return []float64{r.Value}
+}
+
 type Results map[string][]Result
 
+// Validate check the results against the metrics of all types of the config.
+// Unknown metrics and missing or extra labels are accepted if the metrics are lenient.
+// The returned error is a *ValidationError listing all problems.
 func (r Results) Validate(cfg *config.Config) error {
 	if len(r) == 0 {
-		return fmt.Errorf("results must not be empty")
+		return &ValidationError{Problems: []ValidationProblem{{Message: "results must not be empty"}}}
 	}
+
+	var names []string
 	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defs := cfg.Metrics.Definitions()
+	var problems []ValidationProblem
+	for _, name := range names {
 		if !model.IsValidMetricName(model.LabelValue(cfg.Metrics.NameFor(name))) {
-			return fmt.Errorf("%q is not a valid metric name", name)
+			problems = append(problems, ValidationProblem{Metric: name, Message: "is not a valid metric name"})
+			continue
+		}
+		metric, known := defs[name]
+		if !known && !cfg.Metrics.Lenient {
+			problems = append(problems, ValidationProblem{Metric: name, Message: "is not defined"})
+			continue
 		}
+		for i, result := range r[name] {
+			index := i
+			problem := func(msg string, args ...interface{}) {
+				problems = append(problems, ValidationProblem{Metric: name, Index: &index, Message: fmt.Sprintf(msg, args...)})
+			}
+			for _, v := range append([]float64{result.Value}, result.Values...) {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					problem("value %v is not a finite number", v)
+				}
+			}
+			if known && metric.Type == config.MetricTypeCounter && result.Value < 0 {
+				problem("value %v of a counter must not be negative", result.Value)
+			}
+			if !known || cfg.Metrics.Lenient {
+				continue
+			}
+			if len(result.Values) > 0 && metric.Type != config.MetricTypeHistogram {
+				problem("values are only supported by histograms")
+			}
+			for _, l := range metric.Labels {
+				if l == labelNode || l == labelExecutionId {
+					continue
+				}
+				if _, ok := result.Labels[l]; !ok {
+					problem("label %q is missing", l)
+				}
+			}
+			var labels []string
+			for l := range result.Labels {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+			for _, l := range labels {
+				if l == labelNode || l == labelExecutionId {
+					problem("label %q is set by the controller", l)
+				} else if !contains(metric.Labels, l) {
+					problem("label %q is not defined", l)
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
 	return nil
 }
 
+// ValidationError custom error returned if the results of a report are invalid
+type ValidationError struct {
+	Problems []ValidationProblem `json:"problems"`
+}
+
+func (e ValidationError) Error() string {
+	var msgs []string
+	for _, p := range e.Problems {
+		msgs = append(msgs, p.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidationProblem a single problem of the results of a report
+type ValidationProblem struct {
+	// Metric the name of the metric, empty if the problem concerns the whole report
+	Metric string `json:"metric,omitempty"`
+	// Index the index of the result within the metric, nil if the problem concerns the whole metric
+	Index   *int   `json:"index,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ValidationProblem) String() string {
+	if p.Metric == "" {
+		return p.Message
+	}
+	if p.Index == nil {
+		return fmt.Sprintf("metric %q %s", p.Metric, p.Message)
+	}
+	return fmt.Sprintf("metric %q result %d: %s", p.Metric, *p.Index, p.Message)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 type customMetric struct {
-	gauge  *prom.GaugeVec
-	labels []string
+	vec     metricVec
+	labels  []string
+	observe func(labels []string, result Result)
+	// cumulative counters and histograms are only counted for the final attempt of a pod
+	cumulative bool
+}
+
+// ExecutionStatus the status of an execution
+type ExecutionStatus struct {
+	Job            string
+	ExecutionID    string
+	PodsStarted    int
+	PodsSucceeded  int
+	PodsFailed     int
+	LastReportTime *time.Time
+}
+
+// Listener is notified when the status of an execution changes
+type Listener interface {
+	// StatusChanged the status of an execution has changed
+	StatusChanged(status ExecutionStatus)
+}
+
+// CompletionHook is called once all pods of an execution are terminated
+type CompletionHook interface {
+	// ExecutionCompleted the execution is completed, the summary is written to the report directory of the execution
+	ExecutionCompleted(summary ExecutionInfo)
+}
+
+// PodState the state of an existing job pod used to restore the cache
+type PodState struct {
+	ExecutionID string
+	Node        string
+	Attempt     int
+	Started     time.Time
+	// Phase the phase of the pod, pods that terminated while the controller was down are terminated on restore
+	Phase corev1.PodPhase
+	Pod   *corev1.Pod
+	// Delete delete the pod if it exceeds the job timeout
+	Delete func() error
+}
+
+// ExecutionInfo the state of an execution
+type ExecutionInfo struct {
+	Job         string     `json:"job"`
+	ExecutionID string     `json:"executionID"`
+	Started     *time.Time `json:"started,omitempty"`
+	// Finished is set when all pods of the execution are terminated
+	Finished       *time.Time     `json:"finished,omitempty"`
+	DurationMillis int64          `json:"durationMillis,omitempty"`
+	Pods           int            `json:"pods"`
+	PodsByStatus   map[string]int `json:"podsByStatus"`
+	Cancelled      bool           `json:"cancelled,omitempty"`
+	// Outcome is set when the execution is completed: succeeded, partially_failed or cancelled
+	Outcome string     `json:"outcome,omitempty"`
+	Files   []string   `json:"files,omitempty"`
+	Nodes   []NodeInfo `json:"nodes,omitempty"`
+}
+
+// NodeInfo the state of the pod of a node within an execution
+type NodeInfo struct {
+	Node           string     `json:"node"`
+	Status         string     `json:"status"`
+	Attempt        int        `json:"attempt"`
+	Started        *time.Time `json:"started,omitempty"`
+	Terminated     *time.Time `json:"terminated,omitempty"`
+	ReportReceived *time.Time `json:"reportReceived,omitempty"`
+	DurationMillis int64      `json:"durationMillis,omitempty"`
+	Files          []string   `json:"files,omitempty"`
+}
+
+// Scheduler triggers and cancels executions on demand
+type Scheduler interface {
+	// Trigger start a new execution of the job immediately and return its id
+	Trigger(jobName string, opts TriggerOptions) (string, error)
+	// Cancel cancel a running execution and delete its remaining pods
+	Cancel(jobName string, executionID string) error
+}
+
+// TriggerOptions the options of a triggered execution
+type TriggerOptions struct {
+	// Nodes restrict the execution to the given nodes
+	Nodes []string `json:"nodes,omitempty"`
+	// NodeSelector restrict the execution to the nodes matching the label selector
+	NodeSelector string `json:"nodeSelector,omitempty"`
 }
@@ -1,6 +1,8 @@
 package lifecycle_test
 
 import (
+	"math"
+
 	"github.com/bakito/batch-job-controller/pkg/config"
 	"github.com/bakito/batch-job-controller/pkg/lifecycle"
 	. "github.com/onsi/ginkgo"
@@ -15,11 +17,14 @@ var _ = Describe("types", func() {
 		)
 		BeforeEach(func() {
 			results = lifecycle.Results{
-				"aaa": []lifecycle.Result{},
+				"aaa": []lifecycle.Result{{Value: 1, Labels: map[string]string{"label_a": "a"}}},
 			}
 			cfg = &config.Config{
 				Metrics: config.Metrics{
 					Prefix: "foo",
+					Gauges: map[string]config.Metric{
+						"aaa": {Labels: []string{"label_a", "node"}},
+					},
 				},
 			}
 		})
@@ -39,5 +44,50 @@ var _ = Describe("types", func() {
 			Ω(err).Should(HaveOccurred())
 			Ω(err.Error()).Should(Equal("results must not be empty"))
 		})
+		It("should list all problems", func() {
+			results["bbb"] = []lifecycle.Result{}
+			results["aaa"] = append(results["aaa"],
+				lifecycle.Result{Value: math.NaN(), Labels: map[string]string{"label_a": "a"}},
+				lifecycle.Result{Value: math.Inf(1), Labels: map[string]string{"label_b": "b", "executionID": "x"}},
+			)
+			err := results.Validate(cfg)
+			Ω(err).Should(HaveOccurred())
+			Ω(err).Should(BeAssignableToTypeOf(&lifecycle.ValidationError{}))
+			Ω(err.Error()).Should(Equal(`metric "aaa" result 1: value NaN is not a finite number; ` +
+				`metric "aaa" result 2: value +Inf is not a finite number; ` +
+				`metric "aaa" result 2: label "label_a" is missing; ` +
+				`metric "aaa" result 2: label "executionID" is set by the controller; ` +
+				`metric "aaa" result 2: label "label_b" is not defined; ` +
+				`metric "bbb" is not defined`))
+		})
+		It("should check the values by metric type", func() {
+			cfg.Metrics.Counters = map[string]config.Metric{"ccc": {}}
+			cfg.Metrics.Histograms = map[string]config.Histogram{"hhh": {}}
+			results["ccc"] = []lifecycle.Result{{Value: -1}}
+			results["hhh"] = []lifecycle.Result{{Values: []float64{1, math.NaN()}}}
+			results["aaa"][0].Values = []float64{1}
+			err := results.Validate(cfg)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(Equal(`metric "aaa" result 0: values are only supported by histograms; ` +
+				`metric "ccc" result 0: value -1 of a counter must not be negative; ` +
+				`metric "hhh" result 0: value NaN is not a finite number`))
+
+			cfg.Metrics.Lenient = true
+			err = results.Validate(cfg)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(Equal(`metric "ccc" result 0: value -1 of a counter must not be negative; ` +
+				`metric "hhh" result 0: value NaN is not a finite number`))
+		})
+		It("should only reject invalid values and names if lenient", func() {
+			cfg.Metrics.Lenient = true
+			results["bbb"] = []lifecycle.Result{{Value: 1}}
+			results["aaa"] = append(results["aaa"], lifecycle.Result{Value: 2, Labels: map[string]string{"label_b": "b"}})
+			Ω(results.Validate(cfg)).ShouldNot(HaveOccurred())
+
+			results["bbb"] = append(results["bbb"], lifecycle.Result{Value: math.Inf(-1)})
+			err := results.Validate(cfg)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(Equal(`metric "bbb" result 1: value -Inf is not a finite number`))
+		})
 	})
 })
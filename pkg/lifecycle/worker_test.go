@@ -0,0 +1,315 @@
+package lifecycle
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bakito/batch-job-controller/pkg/config"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var _ = Describe("worker", func() {
+	var (
+		cfg    *config.Config
+		c      *cache
+		repDir string
+	)
+	BeforeEach(func() {
+		var err error
+		repDir, err = ioutil.TempDir("", "lifecycle")
+		Ω(err).ShouldNot(HaveOccurred())
+		cfg = &config.Config{
+			Name:            "job",
+			ReportDirectory: repDir,
+			ReportHistory:   5,
+			PodPoolSize:     20,
+			Metrics: config.Metrics{
+				Prefix: "worker",
+			},
+		}
+	})
+	JustBeforeEach(func() {
+		cc, err := NewCache(cfg)
+		Ω(err).ShouldNot(HaveOccurred())
+		c = cc.(*cache)
+	})
+	AfterEach(func() {
+		os.RemoveAll(repDir)
+	})
+
+	It("should process hundreds of pods", func() {
+		const pods = 500
+		id, err := c.NewExecution("job")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		wg.Add(pods)
+		var lock sync.Mutex
+		running := 0
+		maxRunning := 0
+
+		for i := 0; i < pods; i++ {
+			node := uuid.New().String()
+			Ω(c.AddPod(&simulatedJob{
+				testJob: testJob{id: id, job: "job", node: node},
+				run: func(int) {
+					defer GinkgoRecover()
+					lock.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					lock.Unlock()
+
+					time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+					c.ReportReceived("job", id, node, nil, Results{})
+
+					lock.Lock()
+					running--
+					lock.Unlock()
+
+					phase := corev1.PodSucceeded
+					if rand.Intn(10) == 0 {
+						phase = corev1.PodFailed
+					}
+					Ω(c.PodTerminated("job", id, node, 1, phase)).ShouldNot(HaveOccurred())
+					// duplicate terminations are ignored
+					Ω(c.PodTerminated("job", id, node, 1, phase)).ShouldNot(HaveOccurred())
+					wg.Done()
+				},
+			})).ShouldNot(HaveOccurred())
+		}
+		Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+		wg.Wait()
+
+		e, err := c.jobs["job"].forID(id)
+		Ω(err).ShouldNot(HaveOccurred())
+		status := e.status()
+		Ω(status.PodsStarted).Should(Equal(pods))
+		Ω(status.PodsSucceeded + status.PodsFailed).Should(Equal(pods))
+		Ω(maxRunning).Should(BeNumerically("<=", cfg.PodPoolSize))
+	})
+
+	Context("timeout", func() {
+		BeforeEach(func() {
+			cfg.PodPoolSize = 10
+			cfg.JobTimeout = metav1.Duration{Duration: 20 * time.Millisecond}
+		})
+		It("should free the workers of hanging pods", func() {
+			const pods = 100
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			deleted := make(chan bool, pods)
+			for i := 0; i < pods; i++ {
+				node := uuid.New().String()
+				job := &simulatedJob{testJob: testJob{id: id, job: "job", node: node, deleted: deleted}}
+				if i%2 == 0 {
+					job.run = func(int) {
+						defer GinkgoRecover()
+						Ω(c.PodTerminated("job", id, node, 1, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+					}
+				}
+				Ω(c.AddPod(job)).ShouldNot(HaveOccurred())
+			}
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+			for i := 0; i < pods/2; i++ {
+				Eventually(deleted, 5*time.Second).Should(Receive())
+			}
+
+			e, err := c.jobs["job"].forID(id)
+			Ω(err).ShouldNot(HaveOccurred())
+			Eventually(func() int {
+				s := e.status()
+				return s.PodsSucceeded + s.PodsFailed
+			}).Should(Equal(pods))
+			Ω(e.status().PodsFailed).Should(Equal(pods / 2))
+		})
+	})
+
+	Context("cancel", func() {
+		BeforeEach(func() {
+			cfg.PodPoolSize = 5
+		})
+		It("should stop the workers and mark the remaining pods as cancelled", func() {
+			// the pods of the pool are running, the others are queued
+			const pods = 10
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			started := make(chan bool, pods)
+			for i := 0; i < pods; i++ {
+				job := &simulatedJob{testJob: testJob{id: id, job: "job", node: uuid.New().String()}}
+				// the pods never terminate
+				job.run = func(int) {
+					started <- true
+				}
+				Ω(c.AddPod(job)).ShouldNot(HaveOccurred())
+			}
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+			for i := 0; i < cfg.PodPoolSize; i++ {
+				Eventually(started).Should(Receive())
+			}
+			Ω(c.ActiveExecutions("job")).Should(Equal([]string{id}))
+
+			Ω(c.Cancel("job", id)).ShouldNot(HaveOccurred())
+			// cancel is idempotent
+			Ω(c.Cancel("job", id)).ShouldNot(HaveOccurred())
+
+			e, err := c.jobs["job"].forID(id)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(e.status().PodsFailed).Should(Equal(pods))
+			info := e.info("job")
+			Ω(info.Cancelled).Should(BeTrue())
+			Ω(info.PodsByStatus).Should(Equal(map[string]int{podStatusCancelled: pods}))
+
+			// the queued pods are not started anymore
+			Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+			Ω(c.AddPod(&testJob{id: id, job: "job", node: "new"})).Should(HaveOccurred())
+
+			active, err := c.ActiveExecutions("job")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(active).Should(BeEmpty())
+		})
+	})
+
+	Context("retry", func() {
+		BeforeEach(func() {
+			cfg.PodPoolSize = 10
+			cfg.Retry = config.Retry{
+				MaxAttempts:     3,
+				Backoff:         metav1.Duration{Duration: time.Millisecond},
+				OnFailure:       true,
+				OnMissingReport: true,
+			}
+			cfg.Metrics.Counters = map[string]config.Metric{"reports": {Help: "r"}}
+			cfg.Metrics.Histograms = map[string]config.Histogram{"values": {Metric: config.Metric{Help: "v"}, Buckets: []float64{1}}}
+		})
+		It("should retry failed pods on the same node", func() {
+			const pods = 50
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			deleted := make(chan bool, pods*3)
+			var lock sync.Mutex
+			attempts := make(map[string]int)
+			for i := 0; i < pods; i++ {
+				node := uuid.New().String()
+				// even pods succeed with the second attempt, odd pods fail always
+				succeed := i%2 == 0
+				job := &simulatedJob{testJob: testJob{id: id, job: "job", node: node, deleted: deleted}}
+				job.run = func(attempt int) {
+					defer GinkgoRecover()
+					lock.Lock()
+					attempts[node] = attempt
+					lock.Unlock()
+
+					if succeed && attempt == 2 {
+						c.ReportReceived("job", id, node, nil, Results{})
+						Ω(c.PodTerminated("job", id, node, attempt, corev1.PodSucceeded)).ShouldNot(HaveOccurred())
+					} else {
+						Ω(c.PodTerminated("job", id, node, attempt, corev1.PodFailed)).ShouldNot(HaveOccurred())
+					}
+					// terminations of previous attempts are ignored
+					Ω(c.PodTerminated("job", id, node, attempt-1, corev1.PodFailed)).ShouldNot(HaveOccurred())
+				}
+				Ω(c.AddPod(job)).ShouldNot(HaveOccurred())
+			}
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+			e, err := c.jobs["job"].forID(id)
+			Ω(err).ShouldNot(HaveOccurred())
+			Eventually(func() int {
+				s := e.status()
+				return s.PodsSucceeded + s.PodsFailed
+			}, 5*time.Second).Should(Equal(pods))
+			Ω(e.status().PodsSucceeded).Should(Equal(pods / 2))
+			Ω(e.status().PodsFailed).Should(Equal(pods / 2))
+			// the pods of all retried attempts are deleted: 1 for succeeding, 2 for failing pods
+			Ω(deleted).Should(HaveLen(pods/2 + pods))
+
+			lock.Lock()
+			defer lock.Unlock()
+			Ω(attempts).Should(HaveLen(pods))
+			for node, attempt := range attempts {
+				p, err := e.pod(node)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(p.attempt).Should(Equal(attempt))
+				Ω(attempt).Should(BeNumerically("<=", cfg.Retry.MaxAttempts))
+			}
+		})
+		It("should count the results of the final attempt only", func() {
+			id, err := c.NewExecution("job")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			done := make(chan struct{})
+			job := &simulatedJob{testJob: testJob{id: id, job: "job", node: "node"}}
+			job.run = func(attempt int) {
+				defer GinkgoRecover()
+				results := Results{
+					"reports": []Result{{Value: float64(attempt)}},
+					"values":  []Result{{Value: float64(attempt)}},
+				}
+				// a re-posted report replaces the results of the same attempt
+				c.ReportReceived("job", id, "node", nil, results)
+				c.ReportReceived("job", id, "node", nil, results)
+				phase := corev1.PodSucceeded
+				if attempt == 1 {
+					phase = corev1.PodFailed
+				}
+				Ω(c.PodTerminated("job", id, "node", attempt, phase)).ShouldNot(HaveOccurred())
+				// reports after the termination of the final attempt are not counted again
+				c.ReportReceived("job", id, "node", nil, results)
+				if attempt == 2 {
+					close(done)
+				}
+			}
+			Ω(c.AddPod(job)).ShouldNot(HaveOccurred())
+			Ω(c.AllAdded("job", id)).ShouldNot(HaveOccurred())
+
+			Eventually(done, 5*time.Second).Should(BeClosed())
+			Ω(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP worker_reports r
+# TYPE worker_reports counter
+worker_reports{executionID="`+id+`",node="node"} 2
+# HELP worker_values v
+# TYPE worker_values histogram
+worker_values_bucket{executionID="`+id+`",node="node",le="1"} 0
+worker_values_bucket{executionID="`+id+`",node="node",le="+Inf"} 1
+worker_values_sum{executionID="`+id+`",node="node"} 2
+worker_values_count{executionID="`+id+`",node="node"} 1
+`), "worker_reports", "worker_values")).ShouldNot(HaveOccurred())
+		})
+	})
+})
+
+// simulatedJob a job that runs the pod simulation of each attempt asynchronously
+type simulatedJob struct {
+	testJob
+	run func(attempt int)
+}
+
+func (j *simulatedJob) Process() error {
+	if j.run != nil {
+		go j.run(j.Attempt())
+	}
+	return nil
+}
+
+func (j *simulatedJob) Retry() Job {
+	next := *j
+	next.attempt = j.Attempt() + 1
+	return &next
+}
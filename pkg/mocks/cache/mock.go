@@ -10,6 +10,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	v1 "k8s.io/api/core/v1"
 	reflect "reflect"
+	time "time"
 )
 
 // MockCache is a mock of Cache interface
@@ -35,6 +36,45 @@ func (m *MockCache) EXPECT() *MockCacheMockRecorder {
 	return m.recorder
 }
 
+// ActiveExecutions mocks base method
+func (m *MockCache) ActiveExecutions(arg0 string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ActiveExecutions", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ActiveExecutions indicates an expected call of ActiveExecutions
+func (mr *MockCacheMockRecorder) ActiveExecutions(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActiveExecutions", reflect.TypeOf((*MockCache)(nil).ActiveExecutions), arg0)
+}
+
+// AddCompletionHook mocks base method
+func (m *MockCache) AddCompletionHook(arg0 lifecycle.CompletionHook) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AddCompletionHook", arg0)
+}
+
+// AddCompletionHook indicates an expected call of AddCompletionHook
+func (mr *MockCacheMockRecorder) AddCompletionHook(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCompletionHook", reflect.TypeOf((*MockCache)(nil).AddCompletionHook), arg0)
+}
+
+// AddListener mocks base method
+func (m *MockCache) AddListener(arg0 lifecycle.Listener) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AddListener", arg0)
+}
+
+// AddListener indicates an expected call of AddListener
+func (mr *MockCacheMockRecorder) AddListener(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddListener", reflect.TypeOf((*MockCache)(nil).AddListener), arg0)
+}
+
 // AddPod mocks base method
 func (m *MockCache) AddPod(arg0 lifecycle.Job) error {
 	m.ctrl.T.Helper()
@@ -50,17 +90,31 @@ func (mr *MockCacheMockRecorder) AddPod(arg0 interface{}) *gomock.Call {
 }
 
 // AllAdded mocks base method
-func (m *MockCache) AllAdded(arg0 string) error {
+func (m *MockCache) AllAdded(arg0, arg1 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AllAdded", arg0)
+	ret := m.ctrl.Call(m, "AllAdded", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AllAdded indicates an expected call of AllAdded
-func (mr *MockCacheMockRecorder) AllAdded(arg0 interface{}) *gomock.Call {
+func (mr *MockCacheMockRecorder) AllAdded(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllAdded", reflect.TypeOf((*MockCache)(nil).AllAdded), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllAdded", reflect.TypeOf((*MockCache)(nil).AllAdded), arg0, arg1)
+}
+
+// Cancel mocks base method
+func (m *MockCache) Cancel(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cancel", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Cancel indicates an expected call of Cancel
+func (mr *MockCacheMockRecorder) Cancel(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockCache)(nil).Cancel), arg0, arg1)
 }
 
 // Config mocks base method
@@ -77,56 +131,181 @@ func (mr *MockCacheMockRecorder) Config() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Config", reflect.TypeOf((*MockCache)(nil).Config))
 }
 
+// Execution mocks base method
+func (m *MockCache) Execution(arg0, arg1 string) (*lifecycle.ExecutionInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Execution", arg0, arg1)
+	ret0, _ := ret[0].(*lifecycle.ExecutionInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Execution indicates an expected call of Execution
+func (mr *MockCacheMockRecorder) Execution(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Execution", reflect.TypeOf((*MockCache)(nil).Execution), arg0, arg1)
+}
+
+// ExecutionOverlapped mocks base method
+func (m *MockCache) ExecutionOverlapped(arg0, arg1 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ExecutionOverlapped", arg0, arg1)
+}
+
+// ExecutionOverlapped indicates an expected call of ExecutionOverlapped
+func (mr *MockCacheMockRecorder) ExecutionOverlapped(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecutionOverlapped", reflect.TypeOf((*MockCache)(nil).ExecutionOverlapped), arg0, arg1)
+}
+
+// Executions mocks base method
+func (m *MockCache) Executions(arg0 string) ([]lifecycle.ExecutionInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Executions", arg0)
+	ret0, _ := ret[0].([]lifecycle.ExecutionInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Executions indicates an expected call of Executions
+func (mr *MockCacheMockRecorder) Executions(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Executions", reflect.TypeOf((*MockCache)(nil).Executions), arg0)
+}
+
 // Has mocks base method
-func (m *MockCache) Has(arg0, arg1 string) bool {
+func (m *MockCache) Has(arg0, arg1, arg2 string) bool {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Has", arg0, arg1)
+	ret := m.ctrl.Call(m, "Has", arg0, arg1, arg2)
 	ret0, _ := ret[0].(bool)
 	return ret0
 }
 
 // Has indicates an expected call of Has
-func (mr *MockCacheMockRecorder) Has(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockCacheMockRecorder) Has(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Has", reflect.TypeOf((*MockCache)(nil).Has), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Has", reflect.TypeOf((*MockCache)(nil).Has), arg0, arg1, arg2)
+}
+
+// LastScheduled mocks base method
+func (m *MockCache) LastScheduled(arg0 string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastScheduled", arg0)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LastScheduled indicates an expected call of LastScheduled
+func (mr *MockCacheMockRecorder) LastScheduled(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastScheduled", reflect.TypeOf((*MockCache)(nil).LastScheduled), arg0)
 }
 
 // NewExecution mocks base method
-func (m *MockCache) NewExecution() string {
+func (m *MockCache) NewExecution(arg0 string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewExecution")
+	ret := m.ctrl.Call(m, "NewExecution", arg0)
 	ret0, _ := ret[0].(string)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // NewExecution indicates an expected call of NewExecution
-func (mr *MockCacheMockRecorder) NewExecution() *gomock.Call {
+func (mr *MockCacheMockRecorder) NewExecution(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewExecution", reflect.TypeOf((*MockCache)(nil).NewExecution), arg0)
+}
+
+// NextSchedule mocks base method
+func (m *MockCache) NextSchedule(arg0 string, arg1 time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "NextSchedule", arg0, arg1)
+}
+
+// NextSchedule indicates an expected call of NextSchedule
+func (mr *MockCacheMockRecorder) NextSchedule(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextSchedule", reflect.TypeOf((*MockCache)(nil).NextSchedule), arg0, arg1)
+}
+
+// Node mocks base method
+func (m *MockCache) Node(arg0, arg1, arg2 string) (*lifecycle.NodeInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Node", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*lifecycle.NodeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Node indicates an expected call of Node
+func (mr *MockCacheMockRecorder) Node(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Node", reflect.TypeOf((*MockCache)(nil).Node), arg0, arg1, arg2)
+}
+
+// NodeRemoved mocks base method
+func (m *MockCache) NodeRemoved(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "NodeRemoved", arg0)
+}
+
+// NodeRemoved indicates an expected call of NodeRemoved
+func (mr *MockCacheMockRecorder) NodeRemoved(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewExecution", reflect.TypeOf((*MockCache)(nil).NewExecution))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NodeRemoved", reflect.TypeOf((*MockCache)(nil).NodeRemoved), arg0)
 }
 
 // PodTerminated mocks base method
-func (m *MockCache) PodTerminated(arg0, arg1 string, arg2 v1.PodPhase) error {
+func (m *MockCache) PodTerminated(arg0, arg1, arg2 string, arg3 int, arg4 v1.PodPhase) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PodTerminated", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "PodTerminated", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // PodTerminated indicates an expected call of PodTerminated
-func (mr *MockCacheMockRecorder) PodTerminated(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockCacheMockRecorder) PodTerminated(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PodTerminated", reflect.TypeOf((*MockCache)(nil).PodTerminated), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PodTerminated", reflect.TypeOf((*MockCache)(nil).PodTerminated), arg0, arg1, arg2, arg3, arg4)
 }
 
 // ReportReceived mocks base method
-func (m *MockCache) ReportReceived(arg0, arg1 string, arg2 error, arg3 lifecycle.Results) {
+func (m *MockCache) ReportReceived(arg0, arg1, arg2 string, arg3 error, arg4 lifecycle.Results) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "ReportReceived", arg0, arg1, arg2, arg3)
+	m.ctrl.Call(m, "ReportReceived", arg0, arg1, arg2, arg3, arg4)
 }
 
 // ReportReceived indicates an expected call of ReportReceived
-func (mr *MockCacheMockRecorder) ReportReceived(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockCacheMockRecorder) ReportReceived(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportReceived", reflect.TypeOf((*MockCache)(nil).ReportReceived), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Restore mocks base method
+func (m *MockCache) Restore(arg0 string, arg1 []lifecycle.PodState) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore
+func (mr *MockCacheMockRecorder) Restore(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockCache)(nil).Restore), arg0, arg1)
+}
+
+// Scheduled mocks base method
+func (m *MockCache) Scheduled(arg0 string, arg1 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scheduled", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Scheduled indicates an expected call of Scheduled
+func (mr *MockCacheMockRecorder) Scheduled(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportReceived", reflect.TypeOf((*MockCache)(nil).ReportReceived), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scheduled", reflect.TypeOf((*MockCache)(nil).Scheduled), arg0, arg1)
 }
@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: sigs.k8s.io/controller-runtime/pkg/client (interfaces: Client,Reader)
+// Source: sigs.k8s.io/controller-runtime/pkg/client (interfaces: Client,Reader,StatusWriter)
 
 // Package mock_client is a generated GoMock package.
 package mock_client
@@ -233,3 +233,64 @@ func (mr *MockReaderMockRecorder) List(arg0, arg1 interface{}, arg2 ...interface
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockReader)(nil).List), varargs...)
 }
+
+// MockStatusWriter is a mock of StatusWriter interface
+type MockStatusWriter struct {
+	ctrl     *gomock.Controller
+	recorder *MockStatusWriterMockRecorder
+}
+
+// MockStatusWriterMockRecorder is the mock recorder for MockStatusWriter
+type MockStatusWriterMockRecorder struct {
+	mock *MockStatusWriter
+}
+
+// NewMockStatusWriter creates a new mock instance
+func NewMockStatusWriter(ctrl *gomock.Controller) *MockStatusWriter {
+	mock := &MockStatusWriter{ctrl: ctrl}
+	mock.recorder = &MockStatusWriterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockStatusWriter) EXPECT() *MockStatusWriterMockRecorder {
+	return m.recorder
+}
+
+// Patch mocks base method
+func (m *MockStatusWriter) Patch(arg0 context.Context, arg1 runtime.Object, arg2 client.Patch, arg3 ...client.PatchOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Patch", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Patch indicates an expected call of Patch
+func (mr *MockStatusWriterMockRecorder) Patch(arg0, arg1, arg2 interface{}, arg3 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockStatusWriter)(nil).Patch), varargs...)
+}
+
+// Update mocks base method
+func (m *MockStatusWriter) Update(arg0 context.Context, arg1 runtime.Object, arg2 ...client.UpdateOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Update", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update
+func (mr *MockStatusWriterMockRecorder) Update(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockStatusWriter)(nil).Update), varargs...)
+}